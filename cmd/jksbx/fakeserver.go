@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"jksbx/internal/pkg/fakesite"
+	"jksbx/internal/pkg/jlog"
+	"jksbx/internal/pkg/profile"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runFakeServer在本地启动假的cas系统和jksb系统，用来离线地检查登录和申报的整个流程。
+func runFakeServer(args []string) error {
+	fs := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	address := fs.String("a", "localhost:8081", "假服务器的监听地址")
+	users := fs.String("users", "test:test", "可以登录的用户，格式为NetID:密码，多名用户用逗号隔开")
+	seed := fs.Int64("seed", 1, "生成验证码的随机种子")
+	outFilename := fs.String("o", "fake-site.json", "假服务器的站点配置（站点名为fake）写入的文件，相对路径相对于当前目录，已有则覆盖，供其他子命令的-profiles使用；为空则不写")
+	rejectResubmit := fs.Bool("once", false, "同一用户再次提交时返回“今天已经提交过”，用来检查重复申报的处理")
+	proxyAddress := fs.String("proxy", "", "同时在这个地址上启动一个HTTP代理，假服务器只接受经由它的请求，用来检查其他子命令的-proxy，忽略则不启动")
+	socksAddress := fs.String("socks5", "", "同时在这个地址上启动一个SOCKS5代理，假服务器也只接受经由它的请求，用来检查socks5://形式的-proxy，忽略则不启动")
+	fs.Parse(args)
+
+	site, err := fakesite.New(*seed)
+	if err != nil {
+		return err
+	}
+	site.RejectResubmit = *rejectResubmit
+	for _, u := range strings.Split(*users, ",") {
+		parts := strings.SplitN(u, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("用户%s的格式不正确，应为NetID:密码", u)
+		}
+		site.AddUser(parts[0], parts[1])
+	}
+
+	p, err := fakesite.Profile("fake", "http://"+*address)
+	if err != nil {
+		return err
+	}
+	profilesFilename, err := writeFakeProfile(p, *outFilename)
+	if err != nil {
+		return err
+	}
+
+	if *proxyAddress != "" {
+		site.RequireProxy = true
+		p := fakesite.NewProxy()
+		go func() {
+			if err := http.ListenAndServe(*proxyAddress, p); err != nil {
+				jlog.Errorf("代理退出：%s", err.Error())
+			}
+		}()
+		jlog.Infof("代理启动，地址为http://%s，假服务器只接受经由它的请求", *proxyAddress)
+	}
+	if *socksAddress != "" {
+		site.RequireProxy = true
+		site.Socks = fakesite.NewSocks5()
+		ln, err := net.Listen("tcp", *socksAddress)
+		if err != nil {
+			return err
+		}
+		go site.Socks.Serve(ln)
+		jlog.Infof("SOCKS5代理启动，地址为socks5://%s，假服务器只接受经由代理的请求", *socksAddress)
+	}
+
+	jlog.Infof("假服务器启动，地址为http://%s", *address)
+	jlog.Infof("验证码由合成器生成，可以先用 jksbx train synth 和 jksbx train fit 训练一个模型，再用 -m 指定")
+	if profilesFilename != "" {
+		jlog.Infof("例如：jksbx submit -u <NetID> -p <密码> -profiles %s -site fake -m <模型文件>", profilesFilename)
+	}
+	return http.ListenAndServe(*address, site)
+}
+
+// writeFakeProfile把假服务器的站点配置写入filename，返回写入的绝对路径。filename为空时不写，
+// 返回空串。
+func writeFakeProfile(p *profile.Profile, filename string) (string, error) {
+	if filename == "" {
+		jlog.Infof("-o为空，站点配置不写入文件")
+		return "", nil
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent([]*profile.Profile{p}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(abs, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("无法写入站点配置%s：%s", abs, err.Error())
+	}
+	jlog.Infof("站点配置（站点名为fake）已写入%s", abs)
+	return abs, nil
+}
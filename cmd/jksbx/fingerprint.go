@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"jksbx/internal/pkg/fingerprint"
+)
+
+// addFingerprintFlags为子命令注册-fingerprint和-fingerprints参数，返回的函数需要在解析参数之后
+// 调用，用来加载指定的指纹文件，并返回没有指定指纹的用户所用的指纹。
+func addFingerprintFlags(fs *flag.FlagSet) func() (string, error) {
+	name := fs.String("fingerprint", fingerprint.Default, "没有指定指纹的用户所用的浏览器指纹，rotate表示按用户名在所有指纹中轮换")
+	filename := fs.String("fingerprints", "", "浏览器指纹文件（JSON数组），其中的指纹会加入内嵌的指纹，同名则覆盖")
+	return func() (string, error) {
+		if *filename != "" {
+			if err := fingerprint.LoadFile(*filename); err != nil {
+				return "", err
+			}
+		}
+		if _, err := fingerprint.Get(*name, ""); err != nil {
+			return "", err
+		}
+		return *name, nil
+	}
+}
@@ -3,63 +3,73 @@ package main
 import (
 	"bytes"
 	_ "embed"
-	"flag"
-	"jksbx/cmd/jksbx/router"
-	"jksbx/internal/pkg/jlog"
-	"jksbx/internal/pkg/userdb"
+	"fmt"
 	"jksbx/pkg/captcha"
-	"jksbx/pkg/everyday"
-	"net/http"
-	"time"
+	"os"
+	"strings"
 )
 
 //go:embed model.bin
 var defaultModelData []byte
 
-func main() {
-	// 解析命令行参数。
-	headfulMode := flag.Bool("e", false, "是否需要有头浏览器，忽略则为不需要，即用无头浏览器提交健康申报表")
-	everydayHm := flag.Int("s", 730, "每天开始自动申报的时间，格式为24小时制HHMM，如七点半为730，晚上八点整为2000")
-	address := flag.String("a", ":8080", "WEB服务的监听地址，默认监听 0.0.0.0:8080")
-	queueSize := flag.Int("q", 250, "申报请求的队列大小，默认250")
-	concurrency := flag.Int("c", 5, "并发进行申报的协程数目，默认5")
-	userDataFilename := flag.String("u", "user.db", "用户数据库文件路径，忽略则为当前目录的user.db")
-	modelFilename := flag.String("m", "", "OCR模型文件路径，忽略则使用内嵌默认模型")
-	flag.Parse()
+// command是一个子命令，run接收去掉子命令名后的命令行参数。
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
 
-	// 加载OCR模型数据并初始化模型。
-	var m captcha.Model
-	var err error
-	if *modelFilename == "" {
-		m, err = captcha.LoadModel(bytes.NewReader(defaultModelData))
-	} else {
-		m, err = captcha.LoadModelFile(*modelFilename)
-	}
-	if err != nil {
-		panic(err)
+var commands = []command{
+	{"serve", "启动WEB服务，并每天定时为数据库中的用户申报（默认子命令）", runServe},
+	{"train", "交互式训练OCR模型，并保存为模型文件", runTrain},
+	{"submit", "在终端里为一名用户立即提交一次健康申报表", runSubmit},
+	{"user", "直接管理用户数据库：list|add|delete|fields|import|export", runUser},
+	{"model", "查看、评测或标定OCR模型：eval|inspect|bench|denoise|upgrade|calibrate", runModel},
+	{"fake-server", "在本地启动假的cas系统和jksb系统，用来离线地检查整个流程", runFakeServer},
+}
+
+func main() {
+	// 没有子命令，或者直接跟着参数时，保持以前的行为，即启动服务。
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
 	}
-	captcha.Initialize(m)
 
-	// 初始化userdb并启动服务。
-	userdb.Initialize(*userDataFilename)
-	userdb.StartAutoJob(time.Hour)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "jksbx %s: %s\n", name, err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
-	// 初始化每日健康申报任务。
-	hour := *everydayHm / 100
-	minute := *everydayHm % 100
-	if hour < 0 || hour >= 24 || minute < 0 || minute >= 60 {
-		panic("开始申报时间格式不正确")
+	if name != "help" {
+		fmt.Fprintf(os.Stderr, "未知的子命令：%s\n\n", name)
+	}
+	printUsage()
+	if name != "help" {
+		os.Exit(2)
 	}
-	everyday.StartEverydayJob(hour, minute, router.EveryoneSubmitJksb)
+}
 
-	// 初始化WEB服务器。
-	if *queueSize <= 0 || *concurrency <= 0 {
-		panic("队列大小和并发数目必须为正整数")
+// printUsage打印所有子命令的说明。
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "用法：jksbx <子命令> [参数]")
+	fmt.Fprintln(os.Stderr, "\n子命令：")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
 	}
-	router.InitializeApiEndpoints(*headfulMode, *queueSize, *concurrency)
-	jlog.Infof("服务器启动，地址为：%s", *address)
-	err = http.ListenAndServe(*address, nil)
-	if err != nil {
-		panic(err)
+	fmt.Fprintln(os.Stderr, "\n每个子命令传 -h 可以查看参数说明。")
+}
+
+// loadModel加载给定路径的OCR模型，路径为空则加载内嵌默认模型。
+func loadModel(filename string) (*captcha.Model, error) {
+	if filename == "" {
+		return captcha.LoadModel(bytes.NewReader(defaultModelData))
 	}
+	return captcha.LoadModelFile(filename)
 }
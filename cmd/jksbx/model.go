@@ -0,0 +1,338 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"jksbx/pkg/captcha"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runModel查看或评测OCR模型。
+func runModel(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("需要指定操作：eval|inspect|bench|denoise|upgrade|calibrate")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("model "+action, flag.ExitOnError)
+	modelFilename := fs.String("m", "", "OCR模型文件路径，忽略则使用内嵌默认模型")
+	datasetDir := fs.String("d", "", "eval、bench和calibrate使用的数据集目录")
+	folds := fs.Int("k", 0, "eval时做k折交叉验证，评测的是用数据集训练出的新模型，忽略则直接评测给定模型")
+	seed := fs.Int64("seed", 1, "交叉验证时打乱样本的随机种子")
+	rounds := fs.Int("n", 10, "bench时重复识别整个数据集的轮数")
+	inFilename := fs.String("i", "", "denoise时输入的验证码图片")
+	outFilename := fs.String("o", "", "denoise时输出的图片（默认denoise.png），被判定为字符的像素会描成纯红色；upgrade和calibrate时输出的模型文件")
+	solverName := fs.String("solver", "stat", "eval、bench和calibrate使用的识别器，stat为统计模型，mlp为神经网络")
+	fs.Parse(args[1:])
+
+	m, err := loadModel(*modelFilename)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "inspect":
+		inspectModel(m)
+		return nil
+	case "eval":
+		if *datasetDir == "" {
+			return fmt.Errorf("需要用-d指定数据集目录")
+		}
+		return evalModel(m, *solverName, *datasetDir, *folds, *seed)
+	case "bench":
+		if *datasetDir == "" {
+			return fmt.Errorf("需要用-d指定数据集目录")
+		}
+		return benchModel(m, *solverName, *datasetDir, *rounds)
+	case "denoise":
+		if *inFilename == "" {
+			return fmt.Errorf("需要用-i指定验证码图片")
+		}
+		if *outFilename == "" {
+			*outFilename = "denoise.png"
+		}
+		return denoiseImage(m, *inFilename, *outFilename)
+	case "upgrade":
+		if *outFilename == "" {
+			return fmt.Errorf("需要用-o指定输出的模型文件")
+		}
+		return captcha.DumpModelFile(m, *outFilename)
+	case "calibrate":
+		if *datasetDir == "" {
+			return fmt.Errorf("需要用-d指定数据集目录")
+		}
+		if *outFilename == "" {
+			return fmt.Errorf("需要用-o指定输出的模型文件")
+		}
+		return calibrateModel(m, *solverName, *datasetDir, *outFilename)
+	}
+
+	return fmt.Errorf("未知的操作：%s", action)
+}
+
+// inspectModel打印模型中每个字符的样本数目。
+func inspectModel(m *captcha.Model) {
+	counts := m.SampleCounts()
+	chars := make([]rune, 0, len(counts))
+	total := 0
+	for char, samples := range counts {
+		chars = append(chars, char)
+		total += samples
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+
+	if m.Info.FormatVersion == 0 {
+		fmt.Println("格式版本：0（没有元数据的旧格式）")
+	} else {
+		fmt.Printf("格式版本：%d\n", m.Info.FormatVersion)
+	}
+	if m.Version == captcha.FeatureVersion {
+		fmt.Printf("特征版本：%d（%s），使用k近邻分类\n", m.Version, captcha.FeatureType)
+	} else {
+		fmt.Printf("特征版本：%d，当前为%d，只能使用均值模型分类\n", m.Version, captcha.FeatureVersion)
+	}
+	if m.Network != nil {
+		fmt.Printf("神经网络：%d-%d-%d\n", m.Network.Inputs, m.Network.Hidden, m.Network.Outputs)
+	}
+	if m.Threshold == nil {
+		fmt.Println("前景阈值：未学习，使用默认阈值")
+	} else {
+		fmt.Printf("前景阈值：%s\n", m.Threshold)
+	}
+	for _, kind := range []string{captcha.KindKNN, captcha.KindMeans, captcha.KindNeural} {
+		if min, ok := m.ConfidenceThreshold(kind); ok {
+			fmt.Printf("%s的置信度阈值：%.3f（已标定）\n", kind, min)
+		} else {
+			fmt.Printf("%s的置信度阈值：%.3f（默认）\n", kind, min)
+		}
+	}
+	if !m.Info.CreatedAt.IsZero() {
+		fmt.Printf("创建时间：%s\n", m.Info.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	if m.Info.TrainingImages > 0 {
+		fmt.Printf("训练图片数目：%d\n", m.Info.TrainingImages)
+	}
+	if mt := m.Info.Metrics; mt != nil {
+		fmt.Printf("%d折交叉验证（%d张图片）：分割成功率%.2f%%，等宽切分%.2f%%，单字符正确率%.2f%%，整体正确率%.2f%%\n",
+			mt.Folds, mt.NumImages, 100*mt.SegmentationRate, 100*mt.FallbackRate, 100*mt.CharAccuracy, 100*mt.Accuracy)
+	}
+	fmt.Printf("字符数目：%d，样本总数：%d\n", len(chars), total)
+	for _, char := range chars {
+		fmt.Printf("  %c  %d\n", char, counts[char])
+	}
+}
+
+// evalModel用数据集中已标注的图片评测模型的识别器，k大于1时改为对数据集做k折交叉验证，
+// 此时评测的是用数据集训练出的新统计模型，而不是给定的模型。
+func evalModel(m *captcha.Model, solverName, dir string, k int, seed int64) error {
+	d, err := captcha.OpenDataset(dir)
+	if err != nil {
+		return err
+	}
+	samples := d.Labeled()
+	if len(samples) == 0 {
+		return fmt.Errorf("%s中没有已标注的图片", dir)
+	}
+
+	if k <= 1 {
+		recognizer, err := newRecognizer(solverName, m)
+		if err != nil {
+			return err
+		}
+		r, err := captcha.Evaluate(recognizer, d, samples)
+		if err != nil {
+			return err
+		}
+		printReport(r)
+		printMinConfidence(r, recognizer)
+		return nil
+	}
+
+	reports, err := captcha.CrossValidate(d, samples, k, seed)
+	if err != nil {
+		return err
+	}
+	total := &captcha.Report{}
+	for i, r := range reports {
+		fmt.Printf("第%d折：分割成功率%.2f%%，等宽切分%.2f%%，单字符正确率%.2f%%，整体正确率%.2f%%\n",
+			i+1, 100*r.SegmentationRate(), 100*r.FallbackRate(), 100*r.CharAccuracy(), 100*r.Accuracy())
+		total.Merge(r)
+	}
+	fmt.Printf("\n%d折交叉验证汇总：\n", k)
+	printReport(total)
+	return nil
+}
+
+// confidenceThresholds是评测报告中列出的置信度阈值，serve登录时使用的阈值另见printMinConfidence。
+var confidenceThresholds = []float64{0.25, 0.5, 0.75, 0.9}
+
+// percent计算a/b的百分比，b为0时返回0。
+func percent(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return 100 * float64(a) / float64(b)
+}
+
+// printReport打印评测报告，混淆矩阵只打印出现过的字符。
+func printReport(r *captcha.Report) {
+	fmt.Printf("图片数目：%d\n", r.NumImages)
+	fmt.Printf("分割成功率：%d/%d (%.2f%%)\n", r.NumSegmented, r.NumImages, 100*r.SegmentationRate())
+	fmt.Printf("退回到等宽切分：%d/%d (%.2f%%)\n", r.NumFallback, r.NumImages, 100*r.FallbackRate())
+	fmt.Printf("单字符正确率：%d/%d (%.2f%%)\n", r.NumCharsCorrect, r.NumChars, 100*r.CharAccuracy())
+	fmt.Printf("整体正确率：%d/%d (%.2f%%)\n", r.NumCorrect, r.NumImages, 100*r.Accuracy())
+
+	if len(r.Scores) > 0 {
+		fmt.Println("\n置信度阈值（登录时只提交置信度不低于阈值的识别结果）：")
+		for _, min := range confidenceThresholds {
+			accepted, correct := r.Acceptance(min)
+			fmt.Printf("  ≥%.2f：提交%d/%d (%.2f%%)，其中正确%d (%.2f%%)\n", min, accepted, r.NumImages,
+				percent(accepted, r.NumImages), correct, percent(correct, accepted))
+		}
+	}
+
+	used := []int{}
+	for i := range r.Confusion {
+		for j := range r.Confusion {
+			if r.Confusion[i][j] > 0 || r.Confusion[j][i] > 0 {
+				used = append(used, i)
+				break
+			}
+		}
+	}
+	if len(used) > 0 {
+		fmt.Println("\n混淆矩阵（行为真实字符，列为识别结果）：")
+		fmt.Print("   ")
+		for _, j := range used {
+			fmt.Printf("%4c", captcha.Alphabet[j])
+		}
+		fmt.Println()
+		for _, i := range used {
+			fmt.Printf("%3c", captcha.Alphabet[i])
+			for _, j := range used {
+				if r.Confusion[i][j] == 0 {
+					fmt.Printf("%4s", ".")
+				} else {
+					fmt.Printf("%4d", r.Confusion[i][j])
+				}
+			}
+			fmt.Println()
+		}
+	}
+
+	if confusions := r.TopConfusions(10); len(confusions) > 0 {
+		fmt.Printf("\n最常见的识别错误：%s\n", strings.Join(confusions, "，"))
+	}
+	if len(r.Worst) > 0 {
+		fmt.Println("\n最差的样本：")
+		for _, e := range r.Worst {
+			if e.Predicted == "" {
+				fmt.Printf("  %s 应为%s，分割失败\n", e.Filename, e.Label)
+			} else {
+				fmt.Printf("  %s 应为%s，识别为%s，距离%.1f\n", e.Filename, e.Label, e.Predicted, e.Distance)
+			}
+		}
+	}
+}
+
+// printMinConfidence打印登录时识别器所用的置信度阈值，以及在评测报告中按它提交的效果。
+func printMinConfidence(r *captcha.Report, recognizer *captcha.Recognizer) {
+	if len(r.Scores) == 0 {
+		return
+	}
+	min := recognizer.MinConfidence()
+	accepted, correct := r.Acceptance(min)
+	fmt.Printf("\n登录时%s的置信度阈值为%.3f：提交%d/%d (%.2f%%)，其中正确%d (%.2f%%)\n", recognizer.Kind(), min,
+		accepted, r.NumImages, percent(accepted, r.NumImages), correct, percent(correct, accepted))
+}
+
+// calibrateModel用数据集评测识别器，为它所用的分类器标定登录时的置信度阈值，
+// 把标定后的模型写入outFilename。数据集不能包含训练用过的图片，否则置信度虚高。
+func calibrateModel(m *captcha.Model, solverName, dir, outFilename string) error {
+	recognizer, err := newRecognizer(solverName, m)
+	if err != nil {
+		return err
+	}
+	d, err := captcha.OpenDataset(dir)
+	if err != nil {
+		return err
+	}
+	samples := d.Labeled()
+	if len(samples) == 0 {
+		return fmt.Errorf("%s中没有已标注的图片", dir)
+	}
+	r, err := captcha.Evaluate(recognizer, d, samples)
+	if err != nil {
+		return err
+	}
+	if _, err := m.Calibrate(recognizer.Kind(), r); err != nil {
+		return err
+	}
+	printMinConfidence(r, recognizer)
+	return captcha.DumpModelFile(m, outFilename)
+}
+
+// benchModel测量识别的吞吐量：先把数据集中所有图片读入内存，再重复识别rounds轮。
+func benchModel(m *captcha.Model, solverName, dir string, rounds int) error {
+	recognizer, err := newRecognizer(solverName, m)
+	if err != nil {
+		return err
+	}
+	d, err := captcha.OpenDataset(dir)
+	if err != nil {
+		return err
+	}
+	images := make([]image.Image, 0, len(d.Samples))
+	for _, s := range d.Samples {
+		img, err := d.Image(s)
+		if err != nil {
+			return err
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("%s中没有图片", dir)
+	}
+
+	start := time.Now()
+	for i := 0; i < rounds; i++ {
+		for _, img := range images {
+			recognizer.Recognize(img)
+		}
+	}
+	duration := time.Since(start)
+
+	n := rounds * len(images)
+	fmt.Printf("识别%d张图片，耗时%s，平均每张%s，每秒%.0f张\n",
+		n, duration, duration/time.Duration(n), float64(n)/duration.Seconds())
+	return nil
+}
+
+// denoiseImage把验证码图片中被模型的阈值判定为字符的像素描成红色，写入输出文件。
+func denoiseImage(m *captcha.Model, inFilename, outFilename string) error {
+	in, err := os.Open(inFilename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outFilename)
+	if err != nil {
+		return err
+	}
+	if err := m.DebugDenoise(img, out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
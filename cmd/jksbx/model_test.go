@@ -0,0 +1,22 @@
+package main
+
+import (
+	"jksbx/pkg/captcha"
+	"testing"
+)
+
+// TestDefaultModelConfidence检查内嵌的默认模型用的是均值模型及其阈值，而不是k近邻的。
+func TestDefaultModelConfidence(t *testing.T) {
+	m, err := loadModel("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := captcha.NewRecognizer(m)
+	if kind := r.Kind(); kind != captcha.KindMeans {
+		t.Fatalf("默认模型的分类器为%s，应为%s", kind, captcha.KindMeans)
+	}
+	want, _ := m.ConfidenceThreshold(captcha.KindMeans)
+	if got := r.MinConfidence(); got != want || got < 0.99 {
+		t.Errorf("默认模型的阈值为%v，应为均值模型的%v", got, want)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"jksbx/internal/pkg/jlog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchReloadSignal起一个协程，每次收到SIGHUP就调用reload重新加载OCR模型。
+func watchReloadSignal(reload func() error) {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGHUP)
+	go func() {
+		for range sigchan {
+			jlog.Infof("收到SIGHUP，重新加载OCR模型")
+			if err := reload(); err != nil {
+				jlog.Errorf("重新加载OCR模型失败：%s", err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// watchReloadSignal在Windows上什么也不做，因为没有SIGHUP，只能通过管理员API重新加载模型。
+func watchReloadSignal(reload func() error) {}
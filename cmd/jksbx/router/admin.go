@@ -0,0 +1,58 @@
+package router
+
+import (
+	"crypto/subtle"
+	"jksbx/internal/pkg/jlog"
+	"net/http"
+)
+
+// InitializeAdminEndpoints为管理员API注册处理函数，这些API需要在请求体里带上token字段，
+// 且与给定的token一致。token为空时不注册任何管理员API。reload用来重新加载OCR模型，
+// manual不为nil时注册人工输入验证码的网页。开启了失败现场的保存时，还会注册下载现场的API，
+// 因此需要在InitializeTraces之后调用。
+func InitializeAdminEndpoints(token string, reload func() error, manual *ManualSolver) {
+	if token == "" {
+		return
+	}
+	if manual != nil {
+		registerManualEndpoints(token, manual)
+	}
+	if traceStore != nil {
+		registerTraceEndpoints(token)
+	}
+
+	// POST /api/admin/reload 重新加载OCR模型文件，不需要重启服务。
+	http.HandleFunc("/api/admin/reload", func(rw http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(rw, r, token) {
+			return
+		}
+
+		if err := reload(); err != nil {
+			jlog.Errorf("重新加载OCR模型失败：%s", err.Error())
+			rw.WriteHeader(500)
+			rw.Write([]byte("重新加载OCR模型失败：" + err.Error()))
+			return
+		}
+		rw.Write([]byte("重新加载OCR模型成功"))
+	})
+}
+
+// checkAdmin检查请求是否为POST方法，且带有正确的token。检查不通过时会写好响应并返回false。
+func checkAdmin(rw http.ResponseWriter, r *http.Request, token string) bool {
+	if r.Method != "POST" {
+		rw.WriteHeader(405)
+		rw.Write([]byte("请求非POST方法"))
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		rw.WriteHeader(400)
+		rw.Write([]byte(err.Error()))
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(token)) != 1 {
+		rw.WriteHeader(403)
+		rw.Write([]byte("token不正确"))
+		return false
+	}
+	return true
+}
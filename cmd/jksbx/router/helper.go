@@ -1,129 +1,450 @@
 package router
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"image"
+	"jksbx/internal/pkg/fingerprint"
 	"jksbx/internal/pkg/jksb"
 	"jksbx/internal/pkg/jlog"
+	"jksbx/internal/pkg/profile"
+	"jksbx/internal/pkg/proxy"
 	"jksbx/internal/pkg/userdb"
 	"jksbx/pkg/captcha"
 	"jksbx/pkg/cas"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 )
 
+const (
+	// loginsPerSolver是每个求解器最多尝试登录的次数，之后换用求解器链中的下一个。
+	loginsPerSolver = 2
+)
+
 // EveryoneSubmitJksb将对目前数据库中的所有用户提交健康申报申请。
 func EveryoneSubmitJksb() {
-	failUsers := map[string]string{}
-	userdb.ForEach(func(username, password string) {
-		if err := submitJskb(username, password); err != nil {
-			failUsers[username] = password
+	failUsers := map[string]userdb.User{}
+	userdb.ForEach(func(u userdb.User) {
+		if err := SubmitJksb(u); err != nil {
+			failUsers[u.Username] = u
 		}
 	})
 
 	for i := 0; i < 2; i++ {
-		for username, password := range failUsers {
-			if err := submitJskb(username, password); err == nil {
+		for username, u := range failUsers {
+			if err := SubmitJksb(u); err == nil {
 				delete(failUsers, username)
 			}
 		}
 	}
 }
 
-// getUserInfoFromForm从请求体中获取用户账户名和密码。如果没找到相关信息，则返回错误。
-func getUserInfoFromForm(r *http.Request) (string, string, error) {
+// getUserInfoFromForm从请求体中获取用户账户名、密码以及可选的站点。如果没找到相关信息，
+// 或者站点不存在，则返回错误。
+func getUserInfoFromForm(r *http.Request) (userdb.User, error) {
 	err := r.ParseForm()
 	if err != nil {
-		return "", "", err
+		return userdb.User{}, err
 	}
 
 	username := r.PostFormValue("username")
 	if username == "" {
-		return "", "", fmt.Errorf("未填写用户名")
+		return userdb.User{}, fmt.Errorf("未填写用户名")
 	}
 	password := r.PostFormValue("password")
 	if password == "" {
-		return "", "", fmt.Errorf("未填写密码")
+		return userdb.User{}, fmt.Errorf("未填写密码")
+	}
+	site := r.PostFormValue("site")
+	if _, err := profile.Get(site); err != nil {
+		return userdb.User{}, err
 	}
 
-	return username, password, nil
+	return userdb.User{Username: username, Password: password, Site: site}, nil
 }
 
-// submitJksb将根据账户名和密码尝试提交健康申报表。
-func submitJskb(username, password string) error {
-	jlog.Infof("%s Phase 1. 开始登录cas系统", username)
-	tgc, jsessionid := loginCas(username, password)
+// withStoredRecord在u的密码与数据库中的记录相符时，返回数据库中的完整记录，包括要改写的字段、
+// 指纹和代理；表单指定了别的站点，或者用户不在数据库中、密码不符时，原样返回u。
+func withStoredRecord(u userdb.User) userdb.User {
+	if !userdb.CheckUser(u.Username, u.Password) {
+		return u
+	}
+	stored, ok := userdb.GetUser(u.Username)
+	if !ok || u.Site != "" && u.Site != stored.Site {
+		return u
+	}
+	return stored
+}
+
+// casEntry是一名用户缓存的cas客户端。credential是登录得到这个TGC时所用密码的摘要，只有
+// 密码与之相同时才能复用TGC，否则知道用户名的人随便填个密码就能用别人的登录态提交。fingerprint
+// 是登录时所用指纹的名字，TGC与请求头部绑定，换了指纹就要重新登录。proxy是客户端所用的代理，
+// 客户端之后的请求都经由它，换了代理也要新建客户端。mutex让同一名用户的登录串行进行，比如每天的
+// 定时申报和/api/submit同时处理同一名用户时。
+type casEntry struct {
+	mutex       sync.Mutex
+	client      *cas.Client
+	credential  [sha256.Size]byte
+	fingerprint string
+	proxy       string
+}
+
+// reusable检查缓存的客户端是否是用户u用指纹fp、代理proxyUrl登录的，调用时需要持有e.mutex。
+func (e *casEntry) reusable(u userdb.User, fp *fingerprint.Fingerprint, proxyUrl *url.URL) bool {
+	cred := credentialOf(u)
+	return e.client != nil && subtle.ConstantTimeCompare(e.credential[:], cred[:]) == 1 &&
+		e.fingerprint == fp.Name && e.proxy == proxyString(proxyUrl)
+}
+
+// set把用户u用指纹fp、代理proxyUrl登录的客户端c存入缓存，调用时需要持有e.mutex。
+func (e *casEntry) set(c *cas.Client, u userdb.User, fp *fingerprint.Fingerprint, proxyUrl *url.URL) {
+	e.client, e.credential, e.fingerprint, e.proxy = c, credentialOf(u), fp.Name, proxyString(proxyUrl)
+}
+
+// proxyString返回代理的地址，nil即直接连接时为空串。
+func proxyString(proxyUrl *url.URL) string {
+	if proxyUrl == nil {
+		return ""
+	}
+	return proxyUrl.String()
+}
+
+// casClients缓存每名用户的cas客户端，键为站点名和用户名，TGC仍然有效时可以直接复用。
+var casClients = map[string]*casEntry{}
+var casClientsMutex sync.Mutex
+
+// credentialOf返回用户u的密码摘要，用来在不保留密码的情况下比较缓存的TGC是不是用同一个密码登录的。
+func credentialOf(u userdb.User) [sha256.Size]byte {
+	return sha256.Sum256([]byte(u.Username + "\x00" + u.Password))
+}
+
+// casClientKey返回用户u在casClients中的键。
+func casClientKey(u userdb.User) string {
+	site := u.Site
+	if site == "" {
+		site = profile.Default
+	}
+	return site + "/" + u.Username
+}
+
+// casEntryFor返回用户u的缓存项，没有则新建一个空的。
+func casEntryFor(u userdb.User) *casEntry {
+	casClientsMutex.Lock()
+	defer casClientsMutex.Unlock()
+	e, ok := casClients[casClientKey(u)]
+	if !ok {
+		e = &casEntry{}
+		casClients[casClientKey(u)] = e
+	}
+	return e
+}
+
+// casCookies让用户u登录站点p的cas系统，返回TGC和JSESSIONID。缓存的TGC仍然有效，并且是用同一个
+// 密码、同一个指纹、同一个代理登录得到的，就直接复用；否则用指纹fp和代理proxyUrl新建客户端登录，成功后替换缓存。
+func casCookies(u userdb.User, p *profile.Profile, service string, fp *fingerprint.Fingerprint, proxyUrl *url.URL) (tgc, jsessionid *http.Cookie, err error) {
+	e := casEntryFor(u)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
-	if tgc == nil {
-		jlog.Errorf("%s登录cas系统失败", username)
-		return fmt.Errorf("登录cas系统失败")
+	if e.reusable(u, fp, proxyUrl) && e.client.Valid(service) {
+		jlog.Infof("%s的TGC仍然有效，直接复用", u.Username)
+		tgc, jsessionid = e.client.Cookies()
+		return tgc, jsessionid, nil
 	}
 
-	jlog.Infof("%s Phase 2. 开始登录jksb系统并提交申报表", username)
-	s := jksb.NewSession(time.Minute*2, fakeHeader["User-Agent"], headful)
-	err := s.LoginJksb(tgc, jsessionid, fakeHeader)
+	c := newCasClient(p, fp, proxyUrl)
+	if !loginCas(c, u.Username, u.Password) {
+		return nil, nil, fmt.Errorf("登录cas系统失败")
+	}
+	e.set(c, u, fp, proxyUrl)
+	tgc, jsessionid = c.Cookies()
+	return tgc, jsessionid, nil
+}
+
+// newCasClient在站点p上用指纹fp和代理proxyUrl新建一个cas客户端。
+func newCasClient(p *profile.Profile, fp *fingerprint.Fingerprint, proxyUrl *url.URL) *cas.Client {
+	c := cas.NewClient(p.Cas, fp.Header())
+	if proxyUrl != nil {
+		c.SetProxy(proxyUrl)
+	}
+	return c
+}
+
+// proxyFor返回用户u所用的出站代理，nil表示直接连接：用户指定了的就用指定的，否则用
+// InitializeSubmitter设置的默认代理。
+func proxyFor(u userdb.User) (*url.URL, error) {
+	s := u.Proxy
+	if s == "" {
+		s = defaultProxy
+	}
+	return proxy.Parse(s)
+}
+
+// fingerprintFor返回用户u所用的浏览器指纹：用户指定了的就用指定的，否则按InitializeSubmitter
+// 设置的指纹挑选。
+func fingerprintFor(u userdb.User) (*fingerprint.Fingerprint, error) {
+	name := u.Fingerprint
+	if name == "" {
+		name = fingerprintPolicy
+	}
+	return fingerprint.Get(name, u.Username)
+}
+
+// forgetCasClient丢弃用户u缓存的cas客户端，比如删除用户之后。
+func forgetCasClient(u userdb.User) {
+	casClientsMutex.Lock()
+	defer casClientsMutex.Unlock()
+	delete(casClients, casClientKey(u))
+}
+
+// deleteUser从数据库中删除一名用户，并清掉为这名用户缓存的cas客户端。缓存按数据库中记录的站点
+// 查找，而不是按表单中的，表单可以不填站点，用户却可能属于别的站点。
+func deleteUser(username string) {
+	if stored, ok := userdb.GetUser(username); ok {
+		forgetCasClient(stored)
+	}
+	userdb.DeleteUser(username)
+}
+
+// SubmitJksb将根据用户的账户名、密码和所属站点尝试提交健康申报表，提交前先改写用户指定的字段，
+// 调用前需要先调用InitializeSubmitter。
+func SubmitJksb(u userdb.User) error {
+	username := u.Username
+	s, err := openJksb(u, false)
 	if err != nil {
-		jlog.Errorf("%s登录jksb系统失败，有可能是网站下线了？%s", username, err.Error())
 		return err
 	}
+	defer s.Close()
 
-	err = s.SubmitJksb()
+	res, err := s.SubmitJksb(u.Fields)
 	if err != nil {
 		jlog.Errorf("%s提交申报表失败：%s", username, err.Error())
+		saveTrace(username, s, err)
 		return err
 	}
 
-	jlog.Infof("%s Phase 3. 成功提交申报表", username)
-	return nil
+	switch res.Outcome {
+	case jksb.Submitted:
+		jlog.Infof("%s Phase 3. 成功提交申报表", username)
+		return nil
+	case jksb.AlreadySubmitted:
+		jlog.Infof("%s Phase 3. 今天已经申报过了，不需要再提交", username)
+		return nil
+	case jksb.ValidationFailed:
+		err = fmt.Errorf("提交申报表失败，%s", res.String())
+		jlog.Errorf("%s%s", username, err.Error())
+		saveTrace(username, s, err)
+		return err
+	default:
+		err = fmt.Errorf("提交申报表失败，%s", res.String())
+		jlog.Errorf("%s%s", username, err.Error())
+		return err
+	}
+}
+
+// DryRunJksb登录jksb系统并点击“下一步”，返回申报表中预填的字段，但不提交，用来检查字段名和
+// 要改写的值。调用前需要先调用InitializeSubmitter。
+func DryRunJksb(u userdb.User) (map[string]string, error) {
+	s, err := openJksb(u, false)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	res, err := s.NextStep()
+	if err == nil && res != nil {
+		err = fmt.Errorf("无法进入填写申报表的一步，%s", res.String())
+	}
+	if err != nil {
+		jlog.Errorf("%s打开申报表失败：%s", u.Username, err.Error())
+		saveTrace(u.Username, s, err)
+		return nil, err
+	}
+	return s.Fields()
+}
+
+// RecordJksb登录jksb系统并打开有头浏览器，由人在浏览器中完成申报，done关闭时把人的操作整理为
+// 流程返回。调用前需要先调用InitializeSubmitter。
+func RecordJksb(u userdb.User, done <-chan struct{}) (*jksb.Script, error) {
+	s, err := openJksb(u, true)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	jlog.Infof("%s已经打开申报表，请在浏览器中手动完成申报", u.Username)
+	<-done
+	return s.StopRecording(), nil
+}
+
+// openJksb登录cas系统和jksb系统，返回停在申报表页面的会话，用完后需要Close。出错时会话已经关闭。
+// record为true时打开有头浏览器并记录人的操作，会话的超时也更长。
+func openJksb(u userdb.User, record bool) (*jksb.Session, error) {
+	username := u.Username
+	p, err := profile.Get(u.Site)
+	if err != nil {
+		jlog.Errorf("%s的站点配置有误：%s", username, err.Error())
+		return nil, err
+	}
+	service, err := p.JksbService()
+	if err != nil {
+		jlog.Errorf("%s的站点配置有误：%s", username, err.Error())
+		return nil, err
+	}
+	fp, err := fingerprintFor(u)
+	if err != nil {
+		jlog.Errorf("%s的浏览器指纹有误：%s", username, err.Error())
+		return nil, err
+	}
+	proxyUrl, err := proxyFor(u)
+	if err != nil {
+		jlog.Errorf("%s的代理设置有误：%s", username, err.Error())
+		return nil, err
+	}
+
+	jlog.Infof("%s Phase 1. 开始登录cas系统", username)
+	tgc, jsessionid, err := casCookies(u, p, service, fp, proxyUrl)
+	if err != nil {
+		jlog.Errorf("%s%s", username, err.Error())
+		return nil, err
+	}
+
+	jlog.Infof("%s Phase 2. 开始登录jksb系统", username)
+	timeout := time.Minute * 2
+	if record {
+		timeout = time.Minute * 30
+	}
+	s := jksb.NewSession(p.Jksb, timeout, fp, proxyUrl, headful || record)
+	if flowScript != nil {
+		s.SetScript(flowScript)
+	}
+	if record {
+		s.StartRecording()
+	}
+	if traceStore != nil {
+		s.EnableTrace()
+	}
+	err = s.LoginJksb(tgc, jsessionid)
+	if errors.Is(err, jksb.ErrOffline) {
+		jlog.Errorf("%s登录jksb系统失败，网站下线了：%s", username, err.Error())
+		s.Close()
+		return nil, err
+	}
+	if err != nil {
+		jlog.Errorf("%s登录jksb系统失败，有可能是网站下线了？%s", username, err.Error())
+		saveTrace(username, s, err)
+		s.Close()
+		return nil, err
+	}
+	return s, nil
 }
 
 // checkPasswordFromCas试图用指定帐号密码登录cas系统，以此来检查密码是否正确。注意如果返回false，
-// 仍然有小概率密码不是错误的，可以检查密码确认无误后重试一次。
-func checkPasswordFromCas(username, password string) bool {
-	jlog.Infof("%s开始通过cas系统检查密码是否正确", username)
-	tgc, _ := loginCas(username, password)
-	return tgc != nil
+// 仍然有小概率密码不是错误的，可以检查密码确认无误后重试一次。登录成功后缓存这次的登录态。
+func checkPasswordFromCas(u userdb.User) bool {
+	jlog.Infof("%s开始通过cas系统检查密码是否正确", u.Username)
+	p, err := profile.Get(u.Site)
+	if err != nil {
+		return false
+	}
+	fp, err := fingerprintFor(u)
+	if err != nil {
+		return false
+	}
+	proxyUrl, err := proxyFor(u)
+	if err != nil {
+		return false
+	}
+	c := newCasClient(p, fp, proxyUrl)
+	if !loginCas(c, u.Username, u.Password) {
+		return false
+	}
+	e := casEntryFor(u)
+	e.mutex.Lock()
+	e.set(c, u, fp, proxyUrl)
+	e.mutex.Unlock()
+	return true
 }
 
-// loginCas试图登录cas系统，返回TGC和JSESSIONID。若失败，TGC为nil。
-func loginCas(username, password string) (*http.Cookie, *http.Cookie) {
+// loginCas用验证码和密码通过客户端c登录cas系统，成功后c中缓存了TGC。
+func loginCas(c *cas.Client, username, password string) bool {
 	numTryLogin := 5
 	numTryCaptcha := 30
 
-	var tgc, jsessionid *http.Cookie
 	for i := 0; i < numTryLogin; i++ {
+		solver := solverFor(i)
 		capt := ""
+		var captchaImage image.Image
 		for j := 0; j < numTryCaptcha; j++ {
-			var captchaImage image.Image
 			var err error
 
-			captchaImage, jsessionid, err = cas.NewSessionAndGetRawCaptcha(fakeHeader)
+			captchaImage, err = c.NewSession()
 			if err != nil {
 				jlog.Warnf("%s获取验证码失败：%s", username, err.Error())
 				break
 			}
-			capt = captcha.Recognize(captchaImage)
-			if capt != "" {
+			res, err := solver.Solve(captchaImage)
+			if err == captcha.ErrUnrecognized {
+				jlog.Warnf("%s验证码无法识别", username)
+				continue
+			}
+			if err != nil {
+				jlog.Warnf("%s求解验证码失败：%s", username, err.Error())
+				break
+			}
+			// 置信度太低时，与其浪费一次登录机会，不如换一张验证码。
+			min := minConfidence(solver)
+			if res.Confidence >= min {
+				capt = res.Text
 				break
 			}
-			jlog.Warnf("%s验证码无法识别", username)
+			jlog.Warnf("%s验证码识别为%s，但置信度%.3f低于%.3f，重新获取", username, res.Text, res.Confidence, min)
 		}
 
 		if capt == "" {
 			continue
 		}
 
-		var err error
-		tgc, err = cas.LoginCas(username, password, capt, jsessionid, fakeHeader)
-		if err != nil {
-			jlog.Warnf("%s登录cas系统时出现问题", username)
-			continue
+		err := c.Login(username, password, capt)
+		if err == nil {
+			captchaLearner.confirm(captchaImage, capt)
+			return true
 		}
-		if tgc != nil {
-			break
+		switch {
+		case errors.Is(err, cas.ErrCaptcha):
+			captchaLearner.reject(captchaImage, capt)
+			jlog.Warnf("%s登录cas系统时，验证码识别错误", username)
+		case errors.Is(err, cas.ErrCredentials), errors.Is(err, cas.ErrLocked):
+			// 密码错误或账户被锁定时重试没有意义，反而可能导致账户被锁定。
+			jlog.Warnf("%s登录cas系统失败：%s", username, err.Error())
+			return false
+		default:
+			jlog.Warnf("%s登录cas系统时出现问题：%s", username, err.Error())
 		}
-		jlog.Warnf("%s登录cas系统时，验证码识别错误，或密码错误", username)
 	}
 
-	return tgc, jsessionid
+	return false
+}
+
+// minConfidence返回提交solver的识别结果所需的最低置信度。自动识别的求解器按所用的分类器
+// 取模型中标定的值（见captcha.Recognizer.MinConfidence），人工输入等其他求解器不设下限。
+func minConfidence(solver captcha.Solver) float64 {
+	if s, ok := solver.(interface{ MinConfidence() float64 }); ok {
+		return s.MinConfidence()
+	}
+	return 0
+}
+
+// solverFor返回第i次尝试登录时所用的求解器，每个求解器尝试loginsPerSolver次，
+// 最后一个求解器用到尝试结束为止。
+func solverFor(i int) captcha.Solver {
+	idx := i / loginsPerSolver
+	if idx >= len(solvers) {
+		idx = len(solvers) - 1
+	}
+	return solvers[idx]
 }
@@ -0,0 +1,258 @@
+package router
+
+import (
+	"jksbx/internal/pkg/fakesite"
+	"jksbx/internal/pkg/fingerprint"
+	"jksbx/internal/pkg/profile"
+	"jksbx/internal/pkg/userdb"
+	"jksbx/pkg/captcha"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+var (
+	synthSolverOnce sync.Once
+	synthSolver     *captcha.Recognizer
+)
+
+// useSynthSolver让登录cas系统时用一个在生成的验证码上训练的识别器，假站点的验证码也是同样生成的。
+func useSynthSolver(t *testing.T) {
+	t.Helper()
+	synthSolverOnce.Do(func() {
+		g, err := captcha.NewGenerator(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := captcha.NewModel()
+		for i := 0; i < 200; i++ {
+			m.AddTrainingData(g.Next())
+		}
+		synthSolver = captcha.NewRecognizer(m)
+	})
+	InitializeSubmitter(false, "", "", []captcha.Solver{synthSolver})
+}
+
+// startFakeSite启动一个有用户test:secret的假站点，注册为名为name的站点。
+func startFakeSite(t *testing.T, name string) (*fakesite.Site, *httptest.Server, *profile.Profile) {
+	t.Helper()
+	useSynthSolver(t)
+	site, err := fakesite.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site.AddUser("test", "secret")
+	srv := site.Start()
+	t.Cleanup(srv.Close)
+	p, err := fakesite.Profile(name, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Register(p)
+	return site, srv, p
+}
+
+// defaultFingerprint返回用户u所用的浏览器指纹。
+func defaultFingerprint(t *testing.T, u userdb.User) *fingerprint.Fingerprint {
+	t.Helper()
+	fp, err := fingerprintFor(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func TestCachedTgcRequiresPassword(t *testing.T) {
+	_, _, p := startFakeSite(t, "fake-password")
+	service, err := p.JksbService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := userdb.User{Username: "test", Password: "secret", Site: p.Name}
+	defer forgetCasClient(u)
+	if !checkPasswordFromCas(u) {
+		t.Fatal("用正确的密码登录失败")
+	}
+	cached, _ := casEntryFor(u).client.Cookies()
+
+	// 密码不对时不能复用缓存的TGC，而是重新登录，登录失败也不能替换缓存。
+	wrong := u
+	wrong.Password = "guess"
+	if _, _, err := casCookies(wrong, p, service, defaultFingerprint(t, u), nil); err == nil {
+		t.Error("密码错误时复用了缓存的TGC")
+	}
+	if tgc, _ := casEntryFor(u).client.Cookies(); tgc != cached {
+		t.Error("密码错误的登录替换了缓存的TGC")
+	}
+
+	tgc, _, err := casCookies(u, p, service, defaultFingerprint(t, u), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgc.Value != cached.Value {
+		t.Error("密码正确时没有复用缓存的TGC")
+	}
+}
+
+// TestCasCookiesConcurrent检查同一名用户同时申报时没有数据竞争，并且只登录一次，需要用-race运行。
+func TestCasCookiesConcurrent(t *testing.T) {
+	_, _, p := startFakeSite(t, "fake-concurrent")
+	service, err := p.JksbService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := userdb.User{Username: "test", Password: "secret", Site: p.Name}
+	defer forgetCasClient(u)
+	fp := defaultFingerprint(t, u)
+
+	tgcs := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range tgcs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tgc, _, err := casCookies(u, p, service, fp, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tgcs[i] = tgc.Value
+		}(i)
+	}
+	wg.Wait()
+	for _, v := range tgcs[1:] {
+		if v != tgcs[0] {
+			t.Errorf("同时申报时登录了不止一次：%v", tgcs)
+			break
+		}
+	}
+}
+
+func TestCachedTgcRequiresSameFingerprint(t *testing.T) {
+	names := fingerprint.Names()
+	if len(names) < 2 {
+		t.Skip("只有一个浏览器指纹")
+	}
+	_, _, p := startFakeSite(t, "fake-fingerprint")
+	service, err := p.JksbService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := userdb.User{Username: "test", Password: "secret", Site: p.Name}
+	defer forgetCasClient(u)
+
+	var tgcs []string
+	for _, name := range []string{names[0], names[0], names[1]} {
+		fp, err := fingerprint.Get(name, u.Username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgc, _, err := casCookies(u, p, service, fp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgcs = append(tgcs, tgc.Value)
+	}
+	if tgcs[0] != tgcs[1] {
+		t.Error("指纹相同时没有复用缓存的TGC")
+	}
+	if tgcs[1] == tgcs[2] {
+		t.Error("换了指纹之后仍然复用了缓存的TGC")
+	}
+}
+
+func TestCachedTgcRequiresSameProxy(t *testing.T) {
+	site, _, p := startFakeSite(t, "fake-proxy")
+	site.RequireProxy = true
+	socks := fakesite.NewSocks5()
+	socksAddr, err := socks.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	site.Socks = socks
+	proxy := fakesite.NewProxy()
+	proxySrv := proxy.Start()
+	defer proxySrv.Close()
+	service, err := p.JksbService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := userdb.User{Username: "test", Password: "secret", Site: p.Name}
+	defer forgetCasClient(u)
+	fp := defaultFingerprint(t, u)
+
+	var tgcs []string
+	for _, addr := range []string{proxySrv.URL, proxySrv.URL, socksAddr} {
+		proxyUrl, err := url.Parse(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgc, _, err := casCookies(u, p, service, fp, proxyUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgcs = append(tgcs, tgc.Value)
+	}
+	if tgcs[0] != tgcs[1] {
+		t.Error("代理相同时没有复用缓存的TGC")
+	}
+	if tgcs[1] == tgcs[2] {
+		t.Error("换了代理之后仍然复用了缓存的TGC")
+	}
+	if socks.Connections() == 0 {
+		t.Error("换了代理之后的请求没有经过新的代理")
+	}
+
+	// 改为直接连接之后也不能复用经由代理的客户端，直接连接被站点拒绝。
+	if _, _, err := casCookies(u, p, service, fp, nil); err == nil {
+		t.Error("改为直接连接之后仍然复用了经由代理的客户端")
+	}
+}
+
+func TestWithStoredRecord(t *testing.T) {
+	if err := userdb.Initialize(filepath.Join(t.TempDir(), "user.db")); err != nil {
+		t.Fatal(err)
+	}
+	stored := userdb.User{Username: "test", Password: "secret", Fingerprint: "chrome", Proxy: "direct",
+		Fields: map[string]string{"fieldA": "1"}}
+	userdb.AddUser(stored)
+
+	u := withStoredRecord(userdb.User{Username: "test", Password: "secret"})
+	if !reflect.DeepEqual(u, stored) {
+		t.Errorf("密码正确时为%+v，应为数据库中的记录%+v", u, stored)
+	}
+	for _, form := range []userdb.User{
+		{Username: "test", Password: "guess"},
+		{Username: "test", Password: "secret", Site: "other"},
+		{Username: "nobody", Password: "secret"},
+	} {
+		if u := withStoredRecord(form); !reflect.DeepEqual(u, form) {
+			t.Errorf("表单为%+v时用了数据库中的记录%+v", form, u)
+		}
+	}
+}
+
+func TestDeleteUserForgetsStoredSite(t *testing.T) {
+	if err := userdb.Initialize(filepath.Join(t.TempDir(), "user.db")); err != nil {
+		t.Fatal(err)
+	}
+	stored := userdb.User{Username: "test", Password: "secret", Site: "other"}
+	userdb.AddUser(stored)
+	casEntryFor(stored)
+
+	// 表单没有填站点，缓存的键却是数据库中记录的站点。
+	deleteUser("test")
+	if _, ok := userdb.GetUser("test"); ok {
+		t.Error("用户没有从数据库中删除")
+	}
+	casClientsMutex.Lock()
+	_, cached := casClients[casClientKey(stored)]
+	casClientsMutex.Unlock()
+	if cached {
+		t.Error("删除用户后仍然缓存着其所在站点的cas客户端")
+	}
+}
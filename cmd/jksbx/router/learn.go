@@ -0,0 +1,66 @@
+package router
+
+import (
+	"image"
+	"jksbx/internal/pkg/jlog"
+	"jksbx/pkg/captcha"
+	"path/filepath"
+)
+
+// learner收集登录cas系统时用过的验证码：登录成功说明识别结果是正确的，可以当作
+// 标注好的训练数据；登录失败的验证码则放进待审核的数据集，由人工标注。
+type learner struct {
+	confirmed *captcha.Dataset
+	review    *captcha.Dataset
+	online    *captcha.Recognizer
+}
+
+var captchaLearner *learner
+
+// InitializeLearner开启验证码的在线学习。dir不为空时，登录成功的验证码及其识别结果存入
+// dir数据集，登录失败的存入dir/review数据集（不加标注）；online不为nil时，登录成功的
+// 验证码还会直接加入该识别器当前的模型（只在内存中，重启后需要用数据集重新训练）。
+func InitializeLearner(dir string, online *captcha.Recognizer) error {
+	l := &learner{online: online}
+	if dir != "" {
+		var err error
+		if l.confirmed, err = captcha.OpenDataset(dir); err != nil {
+			return err
+		}
+		if l.review, err = captcha.OpenDataset(filepath.Join(dir, "review")); err != nil {
+			return err
+		}
+	}
+	if l.confirmed != nil || l.online != nil {
+		captchaLearner = l
+	}
+	return nil
+}
+
+// confirm记录一张确认识别正确的验证码。
+func (l *learner) confirm(captchaImage image.Image, label string) {
+	if l == nil {
+		return
+	}
+	if l.confirmed != nil {
+		if _, err := l.confirmed.Add(captchaImage, label); err != nil {
+			jlog.Warnf("无法保存已确认的验证码：%s", err.Error())
+		}
+	}
+	if l.online != nil && !l.online.Learn(captchaImage, label) {
+		jlog.Warnf("已确认的验证码%s分割失败，无法加入模型", label)
+	}
+}
+
+// reject记录一张登录失败的验证码，guess为当时的识别结果，只记在日志里。
+func (l *learner) reject(captchaImage image.Image, guess string) {
+	if l == nil || l.review == nil {
+		return
+	}
+	idx, err := l.review.Add(captchaImage, "")
+	if err != nil {
+		jlog.Warnf("无法保存待审核的验证码：%s", err.Error())
+		return
+	}
+	jlog.Infof("登录失败的验证码存为%s，当时识别为%s", l.review.Sample(idx).Filename, guess)
+}
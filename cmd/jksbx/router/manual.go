@@ -0,0 +1,167 @@
+package router
+
+import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"jksbx/internal/pkg/jlog"
+	"jksbx/pkg/captcha"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed manual.html
+var manualPage []byte
+
+// ManualSolver把验证码通过网页交给人来输入，实现了captcha.Solver接口。适合放在
+// 求解器链的最后，在自动识别一再失败时使用。
+type ManualSolver struct {
+	timeout time.Duration
+	mutex   sync.Mutex
+	tasks   map[string]*manualTask
+}
+
+// manualTask是一张等待人工输入的验证码。
+type manualTask struct {
+	image   []byte
+	created time.Time
+	answer  chan string
+}
+
+// NewManualSolver新建一个人工求解器，每张验证码最多等待timeout。
+func NewManualSolver(timeout time.Duration) *ManualSolver {
+	return &ManualSolver{timeout: timeout, tasks: map[string]*manualTask{}}
+}
+
+// Solve实现captcha.Solver接口，阻塞直到有人在网页上输入了验证码，或者超时。
+func (s *ManualSolver) Solve(captchaImage image.Image) (*captcha.Result, error) {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, captchaImage); err != nil {
+		return nil, err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+	task := &manualTask{image: buf.Bytes(), created: time.Now(), answer: make(chan string, 1)}
+
+	s.mutex.Lock()
+	s.tasks[id] = task
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.tasks, id)
+		s.mutex.Unlock()
+	}()
+	jlog.Infof("验证码%s等待人工输入", id)
+
+	select {
+	case text := <-task.answer:
+		res := &captcha.Result{Text: text, Confidence: 1}
+		for _, ch := range text {
+			res.Chars = append(res.Chars, []captcha.Candidate{{Char: ch, Probability: 1}})
+		}
+		return res, nil
+	case <-time.After(s.timeout):
+		return nil, fmt.Errorf("等待人工输入验证码超时")
+	}
+}
+
+// answer提交一张验证码的输入，验证码不存在（已超时或已有输入）则返回false。
+func (s *ManualSolver) answer(id, text string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	task, ok := s.tasks[id]
+	if !ok {
+		return false
+	}
+	delete(s.tasks, id)
+	task.answer <- text
+	return true
+}
+
+// manualItem是返回给网页的一张待输入的验证码。
+type manualItem struct {
+	Id string `json:"id"`
+	// Image是PNG格式的data URL。
+	Image string `json:"image"`
+	// Waiting是已经等待的秒数。
+	Waiting int `json:"waiting"`
+}
+
+// pending按等待时间从长到短返回所有待输入的验证码。
+func (s *ManualSolver) pending() []manualItem {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids := make([]string, 0, len(s.tasks))
+	for id := range s.tasks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.tasks[ids[i]].created.Before(s.tasks[ids[j]].created)
+	})
+
+	items := make([]manualItem, 0, len(ids))
+	for _, id := range ids {
+		task := s.tasks[id]
+		items = append(items, manualItem{
+			Id:      id,
+			Image:   "data:image/png;base64," + base64.StdEncoding.EncodeToString(task.image),
+			Waiting: int(time.Since(task.created).Seconds()),
+		})
+	}
+	return items
+}
+
+// registerManualEndpoints为人工输入验证码的网页及其API注册处理函数，API需要管理员token。
+func registerManualEndpoints(token string, s *ManualSolver) {
+	// GET /admin/captcha 人工输入验证码的网页。
+	http.HandleFunc("/admin/captcha", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			rw.WriteHeader(405)
+			rw.Write([]byte("请求非GET方法"))
+			return
+		}
+		rw.Header().Add("Content-Type", "text/html")
+		rw.Write(manualPage)
+	})
+
+	// POST /api/admin/captcha/list 以JSON返回所有等待人工输入的验证码。
+	http.HandleFunc("/api/admin/captcha/list", func(rw http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(rw, r, token) {
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(s.pending())
+	})
+
+	// POST /api/admin/captcha/answer 接收id和text，提交一张验证码的输入。
+	http.HandleFunc("/api/admin/captcha/answer", func(rw http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(rw, r, token) {
+			return
+		}
+		text := strings.ToLower(strings.TrimSpace(r.PostFormValue("text")))
+		if len(text) != 4 {
+			rw.WriteHeader(400)
+			rw.Write([]byte("验证码必须为4个字符"))
+			return
+		}
+		if !s.answer(r.PostFormValue("id"), text) {
+			rw.WriteHeader(404)
+			rw.Write([]byte("验证码不存在，可能已经超时"))
+			return
+		}
+		rw.Write([]byte("提交成功"))
+	})
+}
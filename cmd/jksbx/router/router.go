@@ -2,46 +2,57 @@ package router
 
 import (
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"jksbx/internal/pkg/jksb"
 	"jksbx/internal/pkg/jlog"
 	"jksbx/internal/pkg/userdb"
+	"jksbx/pkg/captcha"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
 
-var fakeHeader map[string]string
+var fingerprintPolicy string
+var defaultProxy string
 var headful bool
+var flowScript *jksb.Script
+var solvers []captcha.Solver
 
 //go:embed index.html
 var indexPage []byte
 
-type userInfo struct {
-	username string
-	password string
+// InitializeSubmitter初始化提交申报表所需的设置，需要指定提交申报表时，是否需要显示浏览器界面
+// （即是否要有头浏览器），没有指定指纹的用户所用的浏览器指纹（名字或fingerprint.Rotate），
+// 没有指定代理的用户所用的出站代理（空串表示不用），以及求解验证码所用的求解器链：前一个求解器
+// 登录失败若干次后，换用下一个。单独提交申报表前必须先调用此函数。
+func InitializeSubmitter(head bool, fp, proxyUrl string, chain []captcha.Solver) {
+	fingerprintPolicy = fp
+	defaultProxy = proxyUrl
+	headful = head
+	solvers = chain
 }
 
-// InitializeApiEndpoints将为所有API入口注册处理函数，需要指定后台提交申报表时，
-// 是否需要显示浏览器界面（即是否要有头浏览器）。
-func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
-	fakeHeader = map[string]string{
-		"Connection":                "keep-alive",
-		"sec-ch-ua":                 `" Not A;Brand";v="99", "Chromium";v="99"`,
-		"sec-ch-ua-mobile":          "?0",
-		"sec-ch-ua-Linux":           `"Linux"`,
-		"Upgrade-Insecure-Requests": "1",
-		"User-Agent":                "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.74 Safari/537.36",
-		"Accept":                    `text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9`,
-		"Sec-Fetch-Site":            "none",
-		"Sec-Fetch-Mode":            "navigate",
-		"Sec-Fetch-User":            "?1",
-		"Sec-Fetch-Dest":            "document",
-		"Accept-Encoding":           "gzip, deflate, br",
-		"Accept-Language":           "en-US,en;q=0.9",
+// InitializeScript指定提交申报表的流程文件，filename为空时用内嵌的默认流程。
+func InitializeScript(filename string) error {
+	if filename == "" {
+		return nil
 	}
-	headful = head
+	sc, err := jksb.LoadScript(filename)
+	if err != nil {
+		return err
+	}
+	flowScript = sc
+	return nil
+}
+
+// InitializeApiEndpoints将为所有API入口注册处理函数，需要指定后台提交申报表时，
+// 是否需要显示浏览器界面（即是否要有头浏览器），浏览器指纹，出站代理，以及求解验证码所用的求解器链。
+func InitializeApiEndpoints(head bool, fp, proxyUrl string, chain []captcha.Solver, queueSize, concurrency int) {
+	InitializeSubmitter(head, fp, proxyUrl, chain)
 
-	requestQueue := make(chan userInfo, queueSize)
+	requestQueue := make(chan userdb.User, queueSize)
 	inQueue := map[string]struct{}{}
 	inQueueMutex := sync.RWMutex{}
 	// 这个值不是那么重要，因此不加锁
@@ -52,17 +63,17 @@ func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
 		go func(goroutineId int) {
 			for {
 				u := <-requestQueue
-				jlog.Infof("协程#%03d开始处理%s，队列大小%d", goroutineId, u.username, len(requestQueue))
+				jlog.Infof("协程#%03d开始处理%s，队列大小%d", goroutineId, u.Username, len(requestQueue))
 
 				startTime := time.Now()
-				err := submitJskb(u.username, u.password)
+				err := SubmitJksb(u)
 				if err == nil {
 					duration := time.Since(startTime)
 					meanDuration = meanDuration*0.75 + duration.Seconds()*0.25
 				}
 
 				inQueueMutex.Lock()
-				delete(inQueue, u.username)
+				delete(inQueue, u.Username)
 				inQueueMutex.Unlock()
 			}
 		}(i)
@@ -75,16 +86,18 @@ func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
 			rw.Write([]byte("请求非POST方法"))
 			return
 		}
-		username, password, err := getUserInfoFromForm(r)
+		u, err := getUserInfoFromForm(r)
 		if err != nil {
 			rw.WriteHeader(400)
 			rw.Write([]byte(err.Error()))
 			return
 		}
+		// 已经添加过的用户按数据库中的记录申报，这样才会用上设置好的字段、指纹和代理。
+		u = withStoredRecord(u)
 
 		// 检查是否已经在队列里
 		inQueueMutex.RLock()
-		_, ok := inQueue[username]
+		_, ok := inQueue[u.Username]
 		inQueueMutex.RUnlock()
 		if ok {
 			rw.WriteHeader(429)
@@ -94,8 +107,8 @@ func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
 
 		inQueueMutex.Lock()
 		select {
-		case requestQueue <- userInfo{username: username, password: password}:
-			inQueue[username] = struct{}{}
+		case requestQueue <- u:
+			inQueue[u.Username] = struct{}{}
 			waiting := float64(len(inQueue)) * meanDuration
 			inQueueMutex.Unlock()
 			msg := fmt.Sprintf("已经加入申请队列中，预计需等待%.0f秒后，可查看微信是否有申报成功提示，如果没有，则表示申报可能失败，最可能的原因是密码错误，还有可能是jksb系统下线了（每天凌晨0点后会下线），极小可能是自动识别验证码错误", waiting)
@@ -114,26 +127,26 @@ func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
 			rw.Write([]byte("请求非POST方法"))
 			return
 		}
-		username, password, err := getUserInfoFromForm(r)
+		u, err := getUserInfoFromForm(r)
 		if err != nil {
 			rw.WriteHeader(400)
 			rw.Write([]byte(err.Error()))
 			return
 		}
 
-		if userdb.ExistsUser(username) {
+		if userdb.ExistsUser(u.Username) {
 			rw.WriteHeader(406)
 			rw.Write([]byte("账户已经存在，不可添加。修改密码请先用旧密码删除，再添加"))
 			return
 		}
 
-		if !checkPasswordFromCas(username, password) {
+		if !checkPasswordFromCas(u) {
 			rw.WriteHeader(406)
 			rw.Write([]byte("密码可能不正确，请检查密码后重试"))
 			return
 		}
 
-		userdb.AddUser(username, password)
+		userdb.AddUser(u)
 		rw.Write([]byte("添加账户成功"))
 	})
 
@@ -144,23 +157,60 @@ func InitializeApiEndpoints(head bool, queueSize, concurrency int) {
 			rw.Write([]byte("请求非POST方法"))
 			return
 		}
-		username, password, err := getUserInfoFromForm(r)
+		u, err := getUserInfoFromForm(r)
 		if err != nil {
 			rw.WriteHeader(400)
 			rw.Write([]byte(err.Error()))
 			return
 		}
 
-		if !userdb.CheckUser(username, password) {
+		if !userdb.CheckUser(u.Username, u.Password) {
 			rw.WriteHeader(406)
 			rw.Write([]byte("密码错误，或账户已经不在数据库中"))
 			return
 		}
 
-		userdb.DeleteUser(username)
+		deleteUser(u.Username)
 		rw.Write([]byte("删除账户成功"))
 	})
 
+	// POST /api/fields 接收username和password，以及若干个以field开头的字段，如果密码正确，则修改这名用户
+	// 提交前要改写的申报表字段，值为空表示不再改写。返回修改后的全部字段。
+	http.HandleFunc("/api/fields", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			rw.WriteHeader(405)
+			rw.Write([]byte("请求非POST方法"))
+			return
+		}
+		u, err := getUserInfoFromForm(r)
+		if err != nil {
+			rw.WriteHeader(400)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+
+		if !userdb.CheckUser(u.Username, u.Password) {
+			rw.WriteHeader(406)
+			rw.Write([]byte("密码错误，或账户不在数据库中"))
+			return
+		}
+
+		changes := map[string]string{}
+		for k, vs := range r.PostForm {
+			if strings.HasPrefix(k, "field") && len(k) > len("field") {
+				changes[k] = strings.TrimSpace(vs[len(vs)-1])
+			}
+		}
+		fields, ok := userdb.SetFields(u.Username, changes)
+		if !ok {
+			rw.WriteHeader(406)
+			rw.Write([]byte("账户已经不在数据库中"))
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(fields)
+	})
+
 	// GET /
 	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
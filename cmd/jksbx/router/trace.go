@@ -0,0 +1,75 @@
+package router
+
+import (
+	"encoding/json"
+	"jksbx/internal/pkg/jksb"
+	"jksbx/internal/pkg/jlog"
+	"jksbx/internal/pkg/tracestore"
+	"net/http"
+	"time"
+)
+
+var traceStore *tracestore.Store
+
+// InitializeTraces开启失败现场的保存。dir不为空时，每次登录jksb系统或提交申报表失败，都把
+// 截图、DOM和HAR存入dir，只保留最新的maxCount个、不超过maxAge的现场，0表示不限制。
+func InitializeTraces(dir string, maxCount int, maxAge time.Duration) error {
+	if dir == "" {
+		return nil
+	}
+	var err error
+	traceStore, err = tracestore.Open(dir, maxCount, maxAge)
+	return err
+}
+
+// saveTrace保存会话s失败时的现场，cause是失败的原因。没有开启时什么都不做。
+func saveTrace(username string, s *jksb.Session, cause error) {
+	if traceStore == nil {
+		return
+	}
+	t, err := s.Capture()
+	if err != nil {
+		jlog.Warnf("%s的现场不完整：%s", username, err.Error())
+	}
+	id, err := traceStore.Save(username, cause, t)
+	if err != nil {
+		jlog.Warnf("无法保存%s的现场：%s", username, err.Error())
+		return
+	}
+	jlog.Infof("%s失败的现场存为%s", username, id)
+}
+
+// registerTraceEndpoints注册查看和下载失败现场的管理员API。
+func registerTraceEndpoints(token string) {
+	// POST /api/admin/traces/list 以JSON数组返回所有保存的现场，最新的在前。
+	http.HandleFunc("/api/admin/traces/list", func(rw http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(rw, r, token) {
+			return
+		}
+		entries, err := traceStore.List()
+		if err != nil {
+			rw.WriteHeader(500)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(entries)
+	})
+
+	// POST /api/admin/traces/file 接收id和file，下载某个现场中的一个文件。
+	http.HandleFunc("/api/admin/traces/file", func(rw http.ResponseWriter, r *http.Request) {
+		if !checkAdmin(rw, r, token) {
+			return
+		}
+		id := r.PostFormValue("id")
+		name := r.PostFormValue("file")
+		path, err := traceStore.Path(id, name)
+		if err != nil {
+			rw.WriteHeader(404)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+		rw.Header().Set("Content-Disposition", `attachment; filename="`+id+"-"+name+`"`)
+		http.ServeFile(rw, r, path)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"jksbx/cmd/jksbx/router"
+	"jksbx/internal/pkg/jlog"
+	"jksbx/internal/pkg/proxy"
+	"jksbx/internal/pkg/userdb"
+	"jksbx/pkg/captcha"
+	"jksbx/pkg/everyday"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runServe启动WEB服务，这是jksbx原本唯一的功能。
+func runServe(args []string) error {
+	// 解析命令行参数。
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	headfulMode := fs.Bool("e", false, "是否需要有头浏览器，忽略则为不需要，即用无头浏览器提交健康申报表")
+	everydayHm := fs.Int("s", 730, "每天开始自动申报的时间，格式为24小时制HHMM，如七点半为730，晚上八点整为2000")
+	address := fs.String("a", ":8080", "WEB服务的监听地址，默认监听 0.0.0.0:8080")
+	queueSize := fs.Int("q", 250, "申报请求的队列大小，默认250")
+	concurrency := fs.Int("c", 5, "并发进行申报的协程数目，默认5")
+	userDataFilename := fs.String("u", "user.db", "用户数据库文件路径，忽略则为当前目录的user.db")
+	modelFilename := fs.String("m", "", "OCR模型文件路径，忽略则使用内嵌默认模型")
+	adminToken := fs.String("t", "", "管理员API的token，忽略则不开放管理员API")
+	learnDir := fs.String("l", "", "在线学习的数据集目录，登录成功的验证码及其识别结果存入其中，失败的存入其下的review目录，忽略则不保存")
+	learnOnline := fs.Bool("learn", false, "是否把登录成功的验证码直接加入内存中的OCR模型")
+	solverConfig := fs.String("solver", "stat", "验证码求解器链，用+连接，前一个登录失败两次后换下一个。可用stat（统计模型）、mlp（神经网络）、manual（在网页/admin/captcha上人工输入，需要-t）")
+	manualTimeout := fs.Duration("manual-timeout", 3*time.Minute, "人工输入一张验证码的最长等待时间")
+	traceDir := fs.String("trace", "", "失败现场的保存目录，登录jksb系统或提交申报表失败时存下截图、DOM和HAR，忽略则不保存")
+	traceKeep := fs.Int("trace-keep", 100, "最多保留的失败现场数目，0表示不限制")
+	traceMaxAge := fs.Duration("trace-max-age", 7*24*time.Hour, "失败现场的最长保留时间，0表示不限制")
+	scriptFilename := fs.String("script", "", "提交申报表的流程文件（JSON），忽略则使用内嵌的默认流程")
+	proxyUrl := fs.String("proxy", "", "登录cas系统和Chrome共用的出站代理，如http://127.0.0.1:8080或socks5://127.0.0.1:1080，用户自己指定了的除外，忽略则直接连接")
+	applyProfiles := addProfileFlags(fs)
+	applyFingerprints := addFingerprintFlags(fs)
+	fs.Parse(args)
+	if err := applyProfiles(); err != nil {
+		return err
+	}
+	fp, err := applyFingerprints()
+	if err != nil {
+		return err
+	}
+	if _, err := proxy.Parse(*proxyUrl); err != nil {
+		return err
+	}
+
+	var manual *router.ManualSolver
+	if strings.Contains(*solverConfig, "manual") {
+		if *adminToken == "" {
+			return fmt.Errorf("人工输入验证码需要用-t指定管理员token")
+		}
+		manual = router.NewManualSolver(*manualTimeout)
+	}
+
+	// 加载OCR模型数据并初始化求解器，之后可以通过SIGHUP或管理员API重新加载模型文件。
+	m, err := loadModel(*modelFilename)
+	if err != nil {
+		return err
+	}
+	solvers, recognizers, err := buildSolvers(*solverConfig, m, manual)
+	if err != nil {
+		return err
+	}
+	reload := func() error {
+		m, err := loadModel(*modelFilename)
+		if err != nil {
+			return err
+		}
+		if err := reloadRecognizers(recognizers, m); err != nil {
+			return err
+		}
+		jlog.Infof("重新加载OCR模型成功，共%d个字符", len(m.Chars))
+		return nil
+	}
+	watchReloadSignal(reload)
+
+	// 在线学习只加入链中第一个识别器的模型（的副本），其他识别器仍使用加载的模型。
+	var online *captcha.Recognizer
+	if *learnOnline && len(recognizers) > 0 {
+		online = recognizers[0]
+	}
+	if err := router.InitializeLearner(*learnDir, online); err != nil {
+		return err
+	}
+	if err := router.InitializeTraces(*traceDir, *traceKeep, *traceMaxAge); err != nil {
+		return err
+	}
+	if err := router.InitializeScript(*scriptFilename); err != nil {
+		return err
+	}
+
+	// 初始化userdb并启动服务。
+	if err := userdb.Initialize(*userDataFilename); err != nil {
+		return err
+	}
+	userdb.StartAutoJob(time.Hour)
+
+	// 初始化每日健康申报任务。
+	hour := *everydayHm / 100
+	minute := *everydayHm % 100
+	if hour < 0 || hour >= 24 || minute < 0 || minute >= 60 {
+		return fmt.Errorf("开始申报时间格式不正确")
+	}
+	everyday.StartEverydayJob(hour, minute, router.EveryoneSubmitJksb)
+
+	// 初始化WEB服务器。
+	if *queueSize <= 0 || *concurrency <= 0 {
+		return fmt.Errorf("队列大小和并发数目必须为正整数")
+	}
+	router.InitializeApiEndpoints(*headfulMode, fp, *proxyUrl, solvers, *queueSize, *concurrency)
+	router.InitializeAdminEndpoints(*adminToken, reload, manual)
+	jlog.Infof("服务器启动，地址为：%s", *address)
+	return http.ListenAndServe(*address, nil)
+}
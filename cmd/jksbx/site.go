@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+	"jksbx/internal/pkg/profile"
+)
+
+// addProfileFlags为子命令注册-profiles参数，返回的函数需要在解析参数之后调用，
+// 用来加载指定的站点配置文件。
+func addProfileFlags(fs *flag.FlagSet) func() error {
+	filename := fs.String("profiles", "", "站点配置文件（JSON数组），其中的站点会加入内嵌的sysu站点，同名则覆盖，忽略则只有sysu")
+	return func() error {
+		if *filename == "" {
+			return nil
+		}
+		return profile.LoadFile(*filename)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"jksbx/cmd/jksbx/router"
+	"jksbx/pkg/captcha"
+	"strings"
+)
+
+// newRecognizer按求解器名用模型构建识别器，stat为统计模型，mlp为模型中的神经网络。
+func newRecognizer(name string, m *captcha.Model) (*captcha.Recognizer, error) {
+	switch name {
+	case "stat":
+		return captcha.NewRecognizer(m), nil
+	case "mlp":
+		return captcha.NewNeuralRecognizer(m)
+	}
+	return nil, fmt.Errorf("未知的求解器：%s", name)
+}
+
+// buildSolvers按配置构建验证码求解器链。config是用+连接的求解器名，如stat+manual，
+// 可用的求解器有stat、mlp和manual，manual为nil时不能使用manual。返回求解器链，
+// 以及链中所有的识别器，重新加载模型时需要逐个Reload。
+func buildSolvers(config string, m *captcha.Model, manual *router.ManualSolver) ([]captcha.Solver, []*captcha.Recognizer, error) {
+	var chain []captcha.Solver
+	var recognizers []*captcha.Recognizer
+	for _, name := range strings.Split(config, "+") {
+		if name == "manual" {
+			if manual == nil {
+				return nil, nil, fmt.Errorf("当前命令不支持人工输入验证码")
+			}
+			chain = append(chain, manual)
+			continue
+		}
+		r, err := newRecognizer(name, m)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, r)
+		recognizers = append(recognizers, r)
+	}
+	return chain, recognizers, nil
+}
+
+// reloadRecognizers把所有识别器换成给定的模型，有一个失败则全部恢复成原来的模型。
+func reloadRecognizers(recognizers []*captcha.Recognizer, m *captcha.Model) error {
+	olds := make([]*captcha.Model, len(recognizers))
+	for i, r := range recognizers {
+		olds[i] = r.Model()
+	}
+	for i, r := range recognizers {
+		if err := r.Reload(m); err != nil {
+			for j := 0; j < i; j++ {
+				recognizers[j].Reload(olds[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"jksbx/cmd/jksbx/router"
+	"jksbx/internal/pkg/proxy"
+	"jksbx/internal/pkg/userdb"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runSubmit在终端里为一名用户立即提交一次健康申报表。
+func runSubmit(args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	username := fs.String("u", "", "要申报的NetID，必填")
+	password := fs.String("p", "", "密码，忽略则先从用户数据库里找，找不到再从stdin读入")
+	headfulMode := fs.Bool("e", false, "是否需要有头浏览器，忽略则为不需要")
+	userDataFilename := fs.String("d", "user.db", "用户数据库文件路径，用来查找未指定的密码和站点")
+	site := fs.String("site", "", "用户所属的站点，忽略则先从用户数据库里找，找不到则为sysu")
+	traceDir := fs.String("trace", "", "失败现场的保存目录，失败时存下截图、DOM和HAR，忽略则不保存")
+	scriptFilename := fs.String("script", "", "提交申报表的流程文件（JSON），忽略则使用内嵌的默认流程")
+	recordFilename := fs.String("record", "", "不自动提交，而是打开有头浏览器，把人手动申报的操作录制为流程文件存到这里")
+	dryRun := fs.Bool("dry-run", false, "只打开申报表，打印预填的字段和将要改写的字段，不提交")
+	proxyUrl := fs.String("proxy", "", "出站代理，如socks5://127.0.0.1:1080，direct表示直接连接，忽略则先从用户数据库里找，找不到则直接连接")
+	modelFilename := fs.String("m", "", "OCR模型文件路径，忽略则使用内嵌默认模型")
+	solverConfig := fs.String("solver", "stat", "验证码求解器链，用+连接，可用stat（统计模型）、mlp（神经网络）")
+	applyProfiles := addProfileFlags(fs)
+	applyFingerprints := addFingerprintFlags(fs)
+	fs.Parse(args)
+
+	if *username == "" {
+		return fmt.Errorf("需要用-u指定NetID")
+	}
+	if err := applyProfiles(); err != nil {
+		return err
+	}
+	fp, err := applyFingerprints()
+	if err != nil {
+		return err
+	}
+
+	u := userdb.User{Username: *username}
+	if *password == "" || *site == "" {
+		u = lookupUser(*userDataFilename, *username)
+	}
+	if *password != "" {
+		u.Password = *password
+	}
+	if *site != "" {
+		u.Site = *site
+	}
+	if *proxyUrl != "" {
+		u.Proxy = *proxyUrl
+	}
+	if _, err := proxy.Parse(u.Proxy); err != nil {
+		return err
+	}
+	if u.Password == "" {
+		fmt.Printf("请输入%s的密码: ", *username)
+		text, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		u.Password = strings.TrimSpace(text)
+	}
+
+	m, err := loadModel(*modelFilename)
+	if err != nil {
+		return err
+	}
+	solvers, _, err := buildSolvers(*solverConfig, m, nil)
+	if err != nil {
+		return err
+	}
+	if err := router.InitializeTraces(*traceDir, 0, 0); err != nil {
+		return err
+	}
+	if err := router.InitializeScript(*scriptFilename); err != nil {
+		return err
+	}
+	router.InitializeSubmitter(*headfulMode, fp, "", solvers)
+	if *recordFilename != "" {
+		return recordScript(u, *recordFilename)
+	}
+	if *dryRun {
+		prefilled, err := router.DryRunJksb(u)
+		if err != nil {
+			return err
+		}
+		printFields(prefilled, u.Fields)
+		return nil
+	}
+	return router.SubmitJksb(u)
+}
+
+// recordScript打开有头浏览器，由人手动完成申报，在终端按回车后把录下的流程存入filename。录下的
+// 流程不完整时不写filename，以免-script加载时才出错，而是存入filename加上.draft的草稿文件，
+// 手动补全后再改名使用，并返回错误。
+func recordScript(u userdb.User, filename string) error {
+	done := make(chan struct{})
+	go func() {
+		fmt.Println("申报表打开后，请在浏览器中手动点击、填写并提交，完成后在这里按回车")
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		close(done)
+	}()
+	sc, err := router.RecordJksb(u, done)
+	if err != nil {
+		return err
+	}
+	if err := sc.Check(); err != nil {
+		draft := filename + ".draft"
+		if saveErr := sc.Save(draft); saveErr != nil {
+			return saveErr
+		}
+		return fmt.Errorf("录下的流程不完整，没有写入%s，草稿存入了%s，手动补全后才能使用：%s", filename, draft, err.Error())
+	}
+	return sc.Save(filename)
+}
+
+// printFields按字段名打印申报表预填的值，以及提交前将会改写成的值。申报表中没有的字段单独列出，
+// 这样的字段会导致提交失败。
+func printFields(prefilled, overrides map[string]string) {
+	names := make([]string, 0, len(prefilled))
+	for name := range prefilled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v, ok := overrides[name]; ok && v != prefilled[name] {
+			fmt.Printf("%s\t%s\t-> %s\n", name, prefilled[name], v)
+		} else {
+			fmt.Printf("%s\t%s\n", name, prefilled[name])
+		}
+	}
+
+	missing := []string{}
+	for name := range overrides {
+		if _, ok := prefilled[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fmt.Printf("%s\t（申报表中没有这个字段）\t-> %s\n", name, overrides[name])
+	}
+}
+
+// lookupUser从用户数据库中查找用户的记录，数据库不存在或没有这名用户则返回只有NetID的记录。
+// 数据库只读地打开，不会被改写。
+func lookupUser(filename, username string) userdb.User {
+	if err := userdb.Load(filename); err != nil {
+		return userdb.User{Username: username}
+	}
+	u, ok := userdb.GetUser(username)
+	if !ok {
+		return userdb.User{Username: username}
+	}
+	return u
+}
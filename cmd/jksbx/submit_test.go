@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLookupUserReadOnly检查submit查找用户时不会改写数据库：旧格式的数据库能读出用户，
+// 文件内容保持原样，不存在的数据库也不会被新建。
+func TestLookupUserReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "user.db")
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(map[string]string{"alice": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := lookupUser(filename, "alice"); u.Password != "secret" {
+		t.Errorf("alice的密码为%q，应为secret", u.Password)
+	}
+	if u := lookupUser(filename, "bob"); u.Username != "bob" || u.Password != "" {
+		t.Errorf("不存在的用户应只有NetID，却为%+v", u)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Error("查找用户时改写了数据库")
+	}
+
+	missing := filepath.Join(dir, "missing.db")
+	if u := lookupUser(missing, "alice"); u.Password != "" {
+		t.Errorf("数据库不存在时不应有密码，却为%q", u.Password)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("查找用户时新建了数据库")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"jksbx/cmd/jksbx/train"
+	"jksbx/internal/pkg/profile"
+	"jksbx/pkg/captcha"
+	"time"
+)
+
+// runTrain训练OCR模型。不指定操作时为交互式训练，结束后把模型写入文件；
+// 另外支持collect、label、fit三个操作来离线地采集、标注数据集并批量训练，以及synth操作
+// 来生成仿cas风格的验证码数据集。
+func runTrain(args []string) error {
+	action := "interactive"
+	if len(args) > 0 && (args[0] == "collect" || args[0] == "label" || args[0] == "fit" || args[0] == "synth") {
+		action = args[0]
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	imageFilename := fs.String("i", "captcha.png", "交互式训练时下载的验证码图片的保存路径，打开这张图片来辨认验证码")
+	outFilename := fs.String("o", "model.bin", "训练好的模型的保存路径")
+	datasetDir := fs.String("d", "", "数据集目录，交互式训练时若指定，则标注好的图片也会存入其中")
+	num := fs.Int("n", 100, "collect时下载、synth时生成的验证码数目")
+	seed := fs.Int64("seed", 1, "synth时的随机种子，相同的种子生成相同的验证码")
+	interval := fs.Duration("t", time.Second, "collect时每两次下载之间的间隔")
+	folds := fs.Int("k", 0, "fit时先做k折交叉验证，把评测结果记录进模型文件，忽略则不评测")
+	hidden := fs.Int("mlp", 0, "fit时再用模板训练一个神经网络存入模型，值为隐层神经元数目（如64），忽略则不训练")
+	epochs := fs.Int("epochs", 40, "训练神经网络的轮数")
+	site := fs.String("site", "", "交互式训练和collect时从哪个站点的cas系统下载验证码，忽略则为sysu")
+	applyProfiles := addProfileFlags(fs)
+	fs.Parse(args)
+	if err := applyProfiles(); err != nil {
+		return err
+	}
+	p, err := profile.Get(*site)
+	if err != nil {
+		return err
+	}
+
+	var d *captcha.Dataset
+	if *datasetDir != "" {
+		var err error
+		if d, err = captcha.OpenDataset(*datasetDir); err != nil {
+			return err
+		}
+	} else if action != "interactive" {
+		return fmt.Errorf("需要用-d指定数据集目录")
+	}
+
+	switch action {
+	case "collect":
+		return train.Collect(p.Cas, d, *num, *interval)
+	case "label":
+		return train.InteractiveLabel(d)
+	case "synth":
+		return train.Synthesize(d, *num, *seed)
+	case "fit":
+		samples := d.Labeled()
+		m := captcha.NewModel()
+		numFailed, err := m.Train(d, samples)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("共%d张已标注的图片，其中%d张分割失败未参与训练\n", len(samples), numFailed)
+		if m.Threshold != nil {
+			fmt.Printf("采用学习到的前景阈值：%s\n", m.Threshold)
+		} else {
+			fmt.Println("学习到的前景阈值不如默认阈值，采用默认阈值")
+		}
+		if *folds > 1 {
+			reports, err := captcha.CrossValidate(d, samples, *folds, 1)
+			if err != nil {
+				return err
+			}
+			total := &captcha.Report{}
+			for _, r := range reports {
+				total.Merge(r)
+			}
+			m.Info.Metrics = captcha.NewMetrics(total, *folds)
+			fmt.Printf("%d折交叉验证：分割成功率%.2f%%，等宽切分%.2f%%，单字符正确率%.2f%%，整体正确率%.2f%%\n",
+				*folds, 100*total.SegmentationRate(), 100*total.FallbackRate(), 100*total.CharAccuracy(), 100*total.Accuracy())
+			// 交叉验证评测的是k近邻，顺便为它标定登录时的置信度阈值。
+			if min, err := m.Calibrate(captcha.KindKNN, total); err == nil {
+				accepted, correct := total.Acceptance(min)
+				fmt.Printf("k近邻的置信度阈值标定为%.3f：提交%.2f%%，其中正确%.2f%%\n",
+					min, percent(accepted, total.NumImages), percent(correct, accepted))
+			}
+		}
+		if *hidden > 0 {
+			loss, err := m.TrainNetwork(*hidden, *epochs, 1)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("神经网络训练完成，隐层%d个神经元，最后一轮平均损失%.4f\n", *hidden, loss)
+		}
+		return saveModel(m, *outFilename)
+	}
+
+	return saveModel(train.InteractiveTrain(p.Cas, *imageFilename, d), *outFilename)
+}
+
+// saveModel把训练好的模型写入文件。
+func saveModel(m *captcha.Model, filename string) error {
+	if len(m.Chars) == 0 {
+		return fmt.Errorf("没有训练任何数据，不保存模型")
+	}
+	if err := captcha.DumpModelFile(m, filename); err != nil {
+		return err
+	}
+	fmt.Printf("模型已保存到%s，共%d个字符\n", filename, len(m.Chars))
+	return nil
+}
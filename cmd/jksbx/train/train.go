@@ -8,13 +8,17 @@ import (
 	"jksbx/pkg/captcha"
 	"jksbx/pkg/cas"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-// InteractiveTrain将会开始进行交互式的训练模式，不断的下载新的验证码图片到
-// 给定的filename中，并提示用户从stdin输入验证码。结束后，返回训练的模型。
-func InteractiveTrain(filename string) captcha.Model {
+// InteractiveTrain将会开始进行交互式的训练模式，不断的从e所指的cas系统下载新的验证码图片到
+// 给定的filename中，并提示用户从stdin输入验证码。若d不为nil，则每张标注好的图片
+// 都会存入数据集中。结束后，返回训练的模型。
+func InteractiveTrain(e cas.Endpoints, filename string, d *captcha.Dataset) *captcha.Model {
 	fmt.Println("开始训练模型，退出请输入q，撤销前一轮训练请输入x")
-	m := captcha.Model{}
+	m := captcha.NewModel()
 	var bufferImage image.Image = nil
 	var bufferLabel string = ""
 	numTrained := 0
@@ -22,7 +26,7 @@ func InteractiveTrain(filename string) captcha.Model {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
-		captchaImage, _, err := cas.NewSessionAndGetRawCaptcha(map[string]string{})
+		captchaImage, _, err := cas.NewSessionAndGetRawCaptcha(e, map[string]string{})
 		if err != nil {
 			fmt.Println("获取验证码失败：" + err.Error())
 			continue
@@ -45,6 +49,7 @@ func InteractiveTrain(filename string) captcha.Model {
 		case "q":
 			if bufferImage != nil {
 				m.AddTrainingData(bufferImage, bufferLabel)
+				saveToDataset(d, bufferImage, bufferLabel)
 			}
 			return m
 		case "x":
@@ -57,6 +62,7 @@ func InteractiveTrain(filename string) captcha.Model {
 					numFailed++
 					fmt.Printf("上一张图片训练失败，目前失败率：%d/%d (%f%%)\n", numFailed, numTrained, 100*float64(numFailed)/float64(numTrained))
 				}
+				saveToDataset(d, bufferImage, bufferLabel)
 			}
 			for len(text) != 4 {
 				fmt.Printf("请重新输入%s所表示的验证码: ", filename)
@@ -68,3 +74,108 @@ func InteractiveTrain(filename string) captcha.Model {
 		}
 	}
 }
+
+// Collect从e所指的cas系统下载n张新的验证码图片，不加标注地存入数据集，每两张之间间隔interval。
+func Collect(e cas.Endpoints, d *captcha.Dataset, n int, interval time.Duration) error {
+	numFailed := 0
+	for i := 0; i < n; {
+		captchaImage, _, err := cas.NewSessionAndGetRawCaptcha(e, map[string]string{})
+		if err != nil {
+			numFailed++
+			fmt.Println("获取验证码失败：" + err.Error())
+			if numFailed >= 10 {
+				return fmt.Errorf("连续%d次获取验证码失败", numFailed)
+			}
+			time.Sleep(interval)
+			continue
+		}
+		numFailed = 0
+
+		if _, err := d.Add(captchaImage, ""); err != nil {
+			return err
+		}
+		i++
+		fmt.Printf("\r已下载%d/%d张", i, n)
+		time.Sleep(interval)
+	}
+	fmt.Println()
+	return nil
+}
+
+// Synthesize用随机种子seed生成n张仿cas风格的验证码，连同标注一起存入数据集，
+// 可以在无法访问cas系统时离线地训练模型。
+func Synthesize(d *captcha.Dataset, n int, seed int64) error {
+	g, err := captcha.NewGenerator(seed)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		img, label := g.Next()
+		if _, err := d.Add(img, label); err != nil {
+			return err
+		}
+		fmt.Printf("\r已生成%d/%d张", i+1, n)
+	}
+	fmt.Println()
+	return nil
+}
+
+// InteractiveLabel逐张提示用户从stdin输入数据集中未标注图片的验证码，每标注一张就写盘一次，
+// 因此可以随时退出，下次会从第一张未标注的图片继续。退出请输入q，撤销上一张的标注请输入x。
+func InteractiveLabel(d *captcha.Dataset) error {
+	fmt.Println("开始标注数据集，退出请输入q，撤销上一张的标注请输入x")
+	reader := bufio.NewReader(os.Stdin)
+	history := []int{}
+
+	for i := 0; i < len(d.Samples); i++ {
+		if d.Samples[i].Label != "" {
+			continue
+		}
+
+		filename := filepath.Join(d.Dir, d.Samples[i].Filename)
+		fmt.Printf("请输入%s所表示的验证码: ", filename)
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		text = strings.ToLower(strings.TrimSpace(text))
+
+		switch {
+		case text == "q":
+			return nil
+		case text == "x":
+			if len(history) == 0 {
+				fmt.Println("没有可以撤销的标注")
+				i--
+				continue
+			}
+			last := history[len(history)-1]
+			history = history[:len(history)-1]
+			if err := d.SetLabel(last, ""); err != nil {
+				return err
+			}
+			i = last - 1
+		case len(text) != 4:
+			fmt.Println("验证码必须是4位")
+			i--
+		default:
+			if err := d.SetLabel(i, text); err != nil {
+				return err
+			}
+			history = append(history, i)
+		}
+	}
+
+	fmt.Println("数据集中的图片已经全部标注完毕")
+	return nil
+}
+
+// saveToDataset把标注好的图片存入数据集，d为nil时什么也不做。
+func saveToDataset(d *captcha.Dataset, captchaImage image.Image, label string) {
+	if d == nil {
+		return
+	}
+	if _, err := d.Add(captchaImage, label); err != nil {
+		fmt.Println("无法保存到数据集：" + err.Error())
+	}
+}
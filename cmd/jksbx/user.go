@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"jksbx/internal/pkg/fingerprint"
+	"jksbx/internal/pkg/profile"
+	"jksbx/internal/pkg/proxy"
+	"jksbx/internal/pkg/userdb"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runUser直接在数据库文件上管理用户，不需要启动服务。
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("需要指定操作：list|add|delete|fields|import|export")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("user "+action, flag.ExitOnError)
+	userDataFilename := fs.String("d", "user.db", "用户数据库文件路径")
+	filename := fs.String("f", "", "import/export使用的CSV文件路径（每行为NetID,密码,站点,指纹,代理,字段，后四列可以省略，字段的格式为field1=值&field2=值），忽略则为stdin/stdout")
+	site := fs.String("site", "", "add和import时用户所属的站点，忽略则为sysu")
+	fp := fs.String("fingerprint", "", "add和import时用户固定使用的浏览器指纹，忽略则由服务挑选")
+	proxyUrl := fs.String("proxy", "", "add和import时用户专用的出站代理，direct表示直接连接，忽略则用服务的默认代理")
+	fingerprintsFilename := fs.String("fingerprints", "", "浏览器指纹文件（JSON数组），用来检查不是内嵌的指纹")
+	applyProfiles := addProfileFlags(fs)
+	fs.Parse(args[1:])
+
+	if err := applyProfiles(); err != nil {
+		return err
+	}
+	if _, err := profile.Get(*site); err != nil {
+		return err
+	}
+	if *fingerprintsFilename != "" {
+		if err := fingerprint.LoadFile(*fingerprintsFilename); err != nil {
+			return err
+		}
+	}
+	if err := checkFingerprint(*fp); err != nil {
+		return err
+	}
+	if _, err := proxy.Parse(*proxyUrl); err != nil {
+		return err
+	}
+	if err := userdb.Initialize(*userDataFilename); err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		for _, username := range sortedUsernames() {
+			u, _ := userdb.GetUser(username)
+			fmt.Printf("%s\t%s\t%s\t%s\n", username, siteName(u.Site), u.Fingerprint, u.Proxy)
+		}
+		return nil
+
+	case "add":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("用法：jksbx user add [-d user.db] [-site 站点] [-fingerprint 指纹] [-proxy 代理] <NetID> <密码>")
+		}
+		u := userdb.User{Username: fs.Arg(0), Password: fs.Arg(1), Site: *site, Fingerprint: *fp, Proxy: *proxyUrl}
+		u.Fields = keptFields(u.Username)
+		userdb.AddUser(u)
+		return userdb.Save()
+
+	case "delete":
+		if fs.NArg() == 0 {
+			return fmt.Errorf("用法：jksbx user delete [-d user.db] <NetID>...")
+		}
+		for _, username := range fs.Args() {
+			if !userdb.ExistsUser(username) {
+				return fmt.Errorf("用户%s不在数据库中", username)
+			}
+			userdb.DeleteUser(username)
+		}
+		return userdb.Save()
+
+	case "fields":
+		if fs.NArg() == 0 {
+			return fmt.Errorf("用法：jksbx user fields [-d user.db] <NetID> [字段名=值]...")
+		}
+		changes := map[string]string{}
+		for _, arg := range fs.Args()[1:] {
+			i := strings.Index(arg, "=")
+			if i <= 0 {
+				return fmt.Errorf("%s格式不正确，应为字段名=值，值为空表示不再改写这个字段", arg)
+			}
+			changes[arg[:i]] = arg[i+1:]
+		}
+		fields, ok := userdb.SetFields(fs.Arg(0), changes)
+		if !ok {
+			return fmt.Errorf("用户%s不在数据库中", fs.Arg(0))
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", name, fields[name])
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return userdb.Save()
+
+	case "import":
+		r := io.Reader(os.Stdin)
+		if *filename != "" {
+			f, err := os.Open(*filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return err
+		}
+		users := make([]userdb.User, 0, len(records))
+		for i, record := range records {
+			u, err := parseUserRecord(record, userdb.User{Site: *site, Fingerprint: *fp, Proxy: *proxyUrl})
+			if err != nil {
+				return fmt.Errorf("第%d行：%s", i+1, err.Error())
+			}
+			if _, err := profile.Get(u.Site); err != nil {
+				return fmt.Errorf("第%d行：%s", i+1, err.Error())
+			}
+			if err := checkFingerprint(u.Fingerprint); err != nil {
+				return fmt.Errorf("第%d行：%s", i+1, err.Error())
+			}
+			if _, err := proxy.Parse(u.Proxy); err != nil {
+				return fmt.Errorf("第%d行：%s", i+1, err.Error())
+			}
+			if len(record) < 6 {
+				u.Fields = keptFields(u.Username)
+			}
+			users = append(users, u)
+		}
+		for _, u := range users {
+			userdb.AddUser(u)
+		}
+		return userdb.Save()
+
+	case "export":
+		w := io.Writer(os.Stdout)
+		if *filename != "" {
+			f, err := os.Create(*filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		cw := csv.NewWriter(w)
+		for _, username := range sortedUsernames() {
+			u, _ := userdb.GetUser(username)
+			if err := cw.Write(userRecord(u)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return fmt.Errorf("未知的操作：%s", action)
+}
+
+// userRecord返回用户u在CSV中的一行，字段按url.Values编码，parseUserRecord可以原样读回。
+func userRecord(u userdb.User) []string {
+	fields := url.Values{}
+	for k, v := range u.Fields {
+		fields.Set(k, v)
+	}
+	return []string{u.Username, u.Password, u.Site, u.Fingerprint, u.Proxy, fields.Encode()}
+}
+
+// parseUserRecord解析CSV中的一行，省略或为空的站点、指纹和代理取defaults中的值。没有第六列时
+// Fields为nil，由调用者决定是否保留数据库中已有的字段。
+func parseUserRecord(record []string, defaults userdb.User) (userdb.User, error) {
+	if len(record) < 2 || len(record) > 6 || record[0] == "" || record[1] == "" {
+		return userdb.User{}, fmt.Errorf("格式不正确，应为NetID,密码,站点,指纹,代理,字段，后四列可以省略")
+	}
+	u := userdb.User{Username: record[0], Password: record[1], Site: defaults.Site, Fingerprint: defaults.Fingerprint, Proxy: defaults.Proxy}
+	if len(record) >= 3 && record[2] != "" {
+		u.Site = record[2]
+	}
+	if len(record) >= 4 && record[3] != "" {
+		u.Fingerprint = record[3]
+	}
+	if len(record) >= 5 && record[4] != "" {
+		u.Proxy = record[4]
+	}
+	if len(record) == 6 && record[5] != "" {
+		fields, err := url.ParseQuery(record[5])
+		if err != nil {
+			return userdb.User{}, fmt.Errorf("字段的格式不正确：%s", err.Error())
+		}
+		u.Fields = make(map[string]string, len(fields))
+		for k, vs := range fields {
+			u.Fields[k] = vs[len(vs)-1]
+		}
+	}
+	return u, nil
+}
+
+// sortedUsernames返回数据库中按字典序排好的所有NetID。
+func sortedUsernames() []string {
+	ret := []string{}
+	userdb.ForEach(func(u userdb.User) {
+		ret = append(ret, u.Username)
+	})
+	sort.Strings(ret)
+	return ret
+}
+
+// keptFields返回数据库中已有用户要改写的申报表字段，重新添加用户时保留，不存在则返回nil。
+func keptFields(username string) map[string]string {
+	u, ok := userdb.GetUser(username)
+	if !ok {
+		return nil
+	}
+	return u.Fields
+}
+
+// siteName返回站点的名字，空串表示默认站点。
+func siteName(site string) string {
+	if site == "" {
+		return profile.Default
+	}
+	return site
+}
+
+// checkFingerprint检查用户固定使用的指纹是否存在，空串表示不固定。
+func checkFingerprint(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := fingerprint.Get(name, "")
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"jksbx/internal/pkg/userdb"
+	"reflect"
+	"testing"
+)
+
+func TestUserRecordRoundTrip(t *testing.T) {
+	users := []userdb.User{
+		{Username: "alice", Password: "p,w\"d", Site: "sysu", Fingerprint: "chrome-100", Proxy: "direct",
+			Fields: map[string]string{"fieldA": "1", "fieldB": "a=b&c d", "fieldC": "中文"}},
+		{Username: "bob", Password: "secret"},
+	}
+	for _, u := range users {
+		got, err := parseUserRecord(userRecord(u), userdb.User{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, u) {
+			t.Errorf("导出再导入后为%+v，应为%+v", got, u)
+		}
+	}
+}
+
+func TestParseUserRecord(t *testing.T) {
+	defaults := userdb.User{Site: "fake", Fingerprint: "rotate", Proxy: "http://localhost:8082"}
+	u, err := parseUserRecord([]string{"alice", "secret"}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := userdb.User{Username: "alice", Password: "secret", Site: "fake", Fingerprint: "rotate", Proxy: "http://localhost:8082"}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("省略后几列时为%+v，应为%+v", u, want)
+	}
+
+	for _, record := range [][]string{
+		{"alice"},
+		{"", "secret"},
+		{"alice", "secret", "", "", "", "fieldA=%zz"},
+		{"alice", "secret", "", "", "", "", "extra"},
+	} {
+		if _, err := parseUserRecord(record, defaults); err == nil {
+			t.Errorf("%q应当格式不正确", record)
+		}
+	}
+}
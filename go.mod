@@ -3,13 +3,19 @@ module jksbx
 go 1.17
 
 require (
-	github.com/chromedp/cdproto v0.0.0-20220217222649-d8c14a5c6edf // indirect
-	github.com/chromedp/chromedp v0.7.8 // indirect
+	github.com/chromedp/cdproto v0.0.0-20220217222649-d8c14a5c6edf
+	github.com/chromedp/chromedp v0.7.8
+	golang.org/x/image v0.5.0
+	golang.org/x/net v0.7.0
+)
+
+require (
 	github.com/chromedp/sysutil v1.0.0 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.1.0 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
-	golang.org/x/sys v0.0.0-20220209214540-3681064d5158 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.7.0 // indirect
 )
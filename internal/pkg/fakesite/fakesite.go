@@ -0,0 +1,443 @@
+/*
+fakesite包实现了一个假的cas系统和jksb系统，用于在不访问学校服务器的情况下，
+端到端地检查模拟登录和提交申报表的整个流程。
+*/
+package fakesite
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"io"
+	"jksbx/internal/pkg/profile"
+	"jksbx/pkg/captcha"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed login.html
+var loginPage string
+
+//go:embed form.html
+var formPage []byte
+
+var loginTemplate = template.Must(template.New("login").Parse(loginPage))
+
+// ticket是cas系统签发的服务票据。
+type ticket struct {
+	username string
+	service  string
+}
+
+// Site是假的cas系统和jksb系统，实现了http.Handler。cas系统在/cas下，jksb系统在/infoplus下，
+// 两者的行为尽量与真实系统一致：登录页面带有一次性的execution，验证码与JSESSIONID绑定且只能
+// 用一次，登录时检查伪造头部，登录成功后下发TGC，访问jksb时通过服务票据登录，申报表页面
+// 按与真实系统相同的次数和顺序发出POST请求。Site可以被多个协程并发使用。
+type Site struct {
+	// RequiredHeaders是登录时必须带上的请求头部，缺少任何一个都会登录失败。
+	RequiredHeaders []string
+	// RequireProxy表示只接受经由Proxy或Socks转发的请求，模拟只能从校园网访问的系统。
+	RequireProxy bool
+	// Socks是RequireProxy时也接受的SOCKS5代理，为nil表示只接受Proxy。
+	Socks *Socks5
+	// RejectResubmit表示同一用户再次提交时doAction返回错误，模拟今天已经申报过了。
+	RejectResubmit bool
+	// Gzip表示请求的Accept-Encoding含有gzip时压缩响应，与真实系统的nginx一样。
+	Gzip bool
+
+	mutex        sync.Mutex
+	gen          *captcha.Generator
+	users        map[string]string
+	captchas     map[string]string
+	executions   map[string]struct{}
+	tgts         map[string]string
+	tickets      map[string]ticket
+	jksbSessions map[string]string
+	submissions  map[string]int
+	lastForms    map[string]url.Values
+	mux          *http.ServeMux
+}
+
+// New新建一个假站点，seed为生成验证码的随机种子。
+func New(seed int64) (*Site, error) {
+	gen, err := captcha.NewGenerator(seed)
+	if err != nil {
+		return nil, err
+	}
+	s := &Site{
+		RequiredHeaders: []string{"User-Agent", "Accept-Language", "sec-ch-ua"},
+		gen:             gen,
+		users:           map[string]string{},
+		captchas:        map[string]string{},
+		executions:      map[string]struct{}{},
+		tgts:            map[string]string{},
+		tickets:         map[string]ticket{},
+		jksbSessions:    map[string]string{},
+		submissions:     map[string]int{},
+		lastForms:       map[string]url.Values{},
+		mux:             http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/cas/captcha.jsp", s.handleCaptcha)
+	s.mux.HandleFunc("/cas/login", s.handleCasLogin)
+	s.mux.HandleFunc("/cas/serviceValidate", s.handleServiceValidate)
+	s.mux.HandleFunc("/infoplus/login", s.handleJksbLogin)
+	s.mux.HandleFunc("/infoplus/form/XNYQSB/start", s.handleForm)
+	s.mux.HandleFunc("/infoplus/interface/", s.handleInterface)
+	return s, nil
+}
+
+// Start在本地随机端口上启动假站点，返回的服务器用完后需要Close。
+func (s *Site) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// Profile返回假站点运行在给定地址（如http://localhost:8081）上时的站点配置，站点名为name。
+func Profile(name, addr string) (*profile.Profile, error) {
+	return profile.FromBaseUrls(name, addr+"/cas", addr)
+}
+
+// AddUser添加一名可以登录的用户。
+func (s *Site) AddUser(username, password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users[username] = password
+}
+
+// Captcha返回给定JSESSIONID当前验证码的内容，没有或已经用过则返回空串。
+func (s *Site) Captcha(jsessionid string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.captchas[jsessionid]
+}
+
+// Submissions返回一名用户成功提交申报表的次数。
+func (s *Site) Submissions(username string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.submissions[username]
+}
+
+// LastForm返回一名用户最近一次成功提交的申报表字段，没有提交过则返回nil。
+func (s *Site) LastForm(username string) url.Values {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastForms[username]
+}
+
+// ServeHTTP实现http.Handler接口。
+func (s *Site) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if s.RequireProxy && !s.viaProxy(r) {
+		rw.WriteHeader(403)
+		rw.Write([]byte("请从校园网访问"))
+		return
+	}
+	if s.Gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		gz := gzip.NewWriter(rw)
+		defer gz.Close()
+		rw.Header().Set("Content-Encoding", "gzip")
+		rw = gzipResponseWriter{ResponseWriter: rw, w: gz}
+	}
+	s.mux.ServeHTTP(rw, r)
+}
+
+// gzipResponseWriter把响应体压缩后写入ResponseWriter。
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (w gzipResponseWriter) WriteHeader(status int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	return w.w.Write(b)
+}
+
+// viaProxy检查请求是否经由Proxy或Socks转发。
+func (s *Site) viaProxy(r *http.Request) bool {
+	if r.Header.Get("Via") == ViaHeader {
+		return true
+	}
+	return s.Socks != nil && s.Socks.Forwarded(r.RemoteAddr)
+}
+
+// handleCaptcha处理GET /cas/captcha.jsp，没有有效的JSESSIONID时新建一个会话。
+func (s *Site) handleCaptcha(rw http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	jsessionid := ""
+	if c, err := r.Cookie("JSESSIONID"); err == nil {
+		if _, ok := s.captchas[c.Value]; ok {
+			jsessionid = c.Value
+		}
+	}
+	if jsessionid == "" {
+		jsessionid = randomId("")
+		http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: jsessionid, Path: "/cas", HttpOnly: true})
+	}
+	img, text := s.gen.Next()
+	s.captchas[jsessionid] = text
+	s.mutex.Unlock()
+
+	rw.Header().Set("Content-Type", "image/jpeg")
+	jpeg.Encode(rw, img, &jpeg.Options{Quality: 95})
+}
+
+// loginData是登录页面模板的数据。
+type loginData struct {
+	Execution string
+	Error     string
+}
+
+// renderLogin返回带有新execution的登录页面，errMsg不为空时显示错误信息。
+func (s *Site) renderLogin(rw http.ResponseWriter, errMsg string) {
+	s.mutex.Lock()
+	execution := randomId("e1s")
+	s.executions[execution] = struct{}{}
+	s.mutex.Unlock()
+
+	rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	loginTemplate.Execute(rw, loginData{Execution: execution, Error: errMsg})
+}
+
+// handleCasLogin处理/cas/login。GET时若已经有有效的TGC且带有service参数，直接签发服务票据并
+// 重定向，否则返回登录页面；POST时检查登录表单，成功则下发TGC。
+func (s *Site) handleCasLogin(rw http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if r.Method == "GET" {
+		if c, err := r.Cookie("TGC"); err == nil && service != "" {
+			s.mutex.Lock()
+			username, ok := s.tgts[c.Value]
+			s.mutex.Unlock()
+			if ok {
+				s.redirectWithTicket(rw, r, username, service)
+				return
+			}
+		}
+		s.renderLogin(rw, "")
+		return
+	}
+	if r.Method != "POST" {
+		rw.WriteHeader(405)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		rw.WriteHeader(400)
+		return
+	}
+	username, errMsg := s.checkLogin(r)
+	if errMsg != "" {
+		s.renderLogin(rw, errMsg)
+		return
+	}
+
+	tgt := randomId("TGT-")
+	s.mutex.Lock()
+	s.tgts[tgt] = username
+	s.mutex.Unlock()
+	http.SetCookie(rw, &http.Cookie{Name: "TGC", Value: tgt, Path: "/cas/", HttpOnly: true, Secure: r.TLS != nil})
+	if service != "" {
+		s.redirectWithTicket(rw, r, username, service)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	rw.Write([]byte("<html><body><h2>登录成功</h2></body></html>"))
+}
+
+// checkLogin检查登录表单，成功返回用户名，失败返回错误信息。execution和验证码无论成功与否都只能用一次。
+func (s *Site) checkLogin(r *http.Request) (string, string) {
+	for _, h := range s.RequiredHeaders {
+		if r.Header.Get(h) == "" {
+			return "", "非法请求"
+		}
+	}
+	if strings.HasPrefix(r.Header.Get("User-Agent"), "Go-http-client") {
+		return "", "非法请求"
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	execution := r.PostFormValue("execution")
+	if _, ok := s.executions[execution]; !ok {
+		return "", "页面已过期，请刷新后重试"
+	}
+	delete(s.executions, execution)
+
+	c, err := r.Cookie("JSESSIONID")
+	if err != nil {
+		return "", "验证码不正确"
+	}
+	text := s.captchas[c.Value]
+	s.captchas[c.Value] = ""
+	if text == "" || !strings.EqualFold(r.PostFormValue("captcha"), text) {
+		return "", "验证码不正确"
+	}
+
+	username := r.PostFormValue("username")
+	if pwd, ok := s.users[username]; !ok || pwd != r.PostFormValue("password") {
+		return "", "认证信息无效。"
+	}
+	return username, ""
+}
+
+// redirectWithTicket签发一张服务票据，并重定向到service。
+func (s *Site) redirectWithTicket(rw http.ResponseWriter, r *http.Request, username, service string) {
+	st := randomId("ST-")
+	s.mutex.Lock()
+	s.tickets[st] = ticket{username: username, service: service}
+	s.mutex.Unlock()
+
+	sep := "?"
+	if strings.Contains(service, "?") {
+		sep = "&"
+	}
+	http.Redirect(rw, r, service+sep+"ticket="+url.QueryEscape(st), http.StatusFound)
+}
+
+// validateTicket校验并销毁一张服务票据，service的前缀必须与签发时的一致，返回用户名。
+func (s *Site) validateTicket(st, servicePrefix string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.tickets[st]
+	if !ok {
+		return "", false
+	}
+	delete(s.tickets, st)
+	if !strings.HasPrefix(t.service, servicePrefix) {
+		return "", false
+	}
+	return t.username, true
+}
+
+// handleServiceValidate处理GET /cas/serviceValidate，按CAS 2.0协议返回XML。
+func (s *Site) handleServiceValidate(rw http.ResponseWriter, r *http.Request) {
+	st := r.URL.Query().Get("ticket")
+	service := r.URL.Query().Get("service")
+	rw.Header().Set("Content-Type", "application/xml;charset=UTF-8")
+	username, ok := "", false
+	if service != "" {
+		username, ok = s.validateTicket(st, service)
+	}
+	if !ok {
+		fmt.Fprintf(rw, "<cas:serviceResponse xmlns:cas='http://www.yale.edu/tp/cas'>\n"+
+			"  <cas:authenticationFailure code=\"INVALID_TICKET\">Ticket %s not recognized</cas:authenticationFailure>\n"+
+			"</cas:serviceResponse>\n", template.HTMLEscapeString(st))
+		return
+	}
+	fmt.Fprintf(rw, "<cas:serviceResponse xmlns:cas='http://www.yale.edu/tp/cas'>\n"+
+		"  <cas:authenticationSuccess>\n    <cas:user>%s</cas:user>\n  </cas:authenticationSuccess>\n"+
+		"</cas:serviceResponse>\n", template.HTMLEscapeString(username))
+}
+
+// baseUrl返回请求所在站点的地址。
+func baseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleJksbLogin处理GET /infoplus/login，用服务票据登录jksb系统，再重定向到申报表页面。
+// 没有票据时重定向到cas系统登录。
+func (s *Site) handleJksbLogin(rw http.ResponseWriter, r *http.Request) {
+	st := r.URL.Query().Get("ticket")
+	if st == "" {
+		s.redirectToCas(rw, r)
+		return
+	}
+	username, ok := s.validateTicket(st, baseUrl(r)+"/infoplus/login")
+	if !ok {
+		rw.WriteHeader(403)
+		rw.Write([]byte("票据无效"))
+		return
+	}
+
+	session := randomId("")
+	s.mutex.Lock()
+	s.jksbSessions[session] = username
+	s.mutex.Unlock()
+	http.SetCookie(rw, &http.Cookie{Name: "JSESSIONID", Value: session, Path: "/infoplus", HttpOnly: true})
+	http.Redirect(rw, r, "/infoplus/form/XNYQSB/start", http.StatusFound)
+}
+
+// redirectToCas重定向到cas系统登录，登录后回到申报表页面。
+func (s *Site) redirectToCas(rw http.ResponseWriter, r *http.Request) {
+	base := baseUrl(r)
+	service := base + "/infoplus/login?retUrl=" + base + "/infoplus/form/XNYQSB/start"
+	http.Redirect(rw, r, base+"/cas/login?service="+service, http.StatusFound)
+}
+
+// jksbUser返回请求所属的jksb会话的用户名。
+func (s *Site) jksbUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie("JSESSIONID")
+	if err != nil {
+		return "", false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	username, ok := s.jksbSessions[c.Value]
+	return username, ok
+}
+
+// handleForm处理GET /infoplus/form/XNYQSB/start，返回申报表页面。
+func (s *Site) handleForm(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := s.jksbUser(r); !ok {
+		s.redirectToCas(rw, r)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	rw.Write(formPage)
+}
+
+// handleInterface处理申报表页面发出的POST /infoplus/interface/*请求，doAction即为提交申报表。
+func (s *Site) handleInterface(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		rw.WriteHeader(405)
+		return
+	}
+	username, ok := s.jksbUser(r)
+	if !ok {
+		rw.WriteHeader(403)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if strings.TrimPrefix(r.URL.Path, "/infoplus/interface/") == "doAction" {
+		r.ParseForm()
+		if tw, err := strconv.ParseFloat(r.PostForm.Get("fieldTW"), 64); err != nil || tw < 35 || tw > 42 {
+			rw.Write([]byte(`{"errno":1,"ecode":"EVENT_REJECTED","error":"体温填写不正确","entities":[]}`))
+			return
+		}
+		s.mutex.Lock()
+		duplicated := s.RejectResubmit && s.submissions[username] > 0
+		if !duplicated {
+			s.submissions[username]++
+			s.lastForms[username] = r.PostForm
+		}
+		s.mutex.Unlock()
+		if duplicated {
+			rw.Write([]byte(`{"errno":1,"ecode":"EVENT_REJECTED","error":"今天已经提交过申报表了，请勿重复提交","entities":[]}`))
+			return
+		}
+	}
+	rw.Write([]byte(`{"errno":0,"ecode":"SUCCEED","entities":[]}`))
+}
+
+// randomId生成一个带前缀的随机id。
+func randomId(prefix string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
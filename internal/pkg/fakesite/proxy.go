@@ -0,0 +1,100 @@
+package fakesite
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// ViaHeader是Proxy转发请求时加上的Via头部，假站点的RequireProxy据此判断请求是否经由代理。
+const ViaHeader = "1.1 jksbx-fake-proxy"
+
+// Proxy是一个最简的HTTP正向代理，代替校外部署时用的代理或校园网VPN网关，支持普通请求和CONNECT，
+// 并统计转发过的请求数目，用来检查Go的HTTP客户端和Chrome是否都经由代理访问。Proxy可以被多个
+// 协程并发使用。
+type Proxy struct {
+	mutex    sync.Mutex
+	requests int
+}
+
+// NewProxy新建一个代理。
+func NewProxy() *Proxy {
+	return &Proxy{}
+}
+
+// Start在本地随机端口上启动代理，返回的服务器用完后需要Close，代理地址为其URL。
+func (p *Proxy) Start() *httptest.Server {
+	return httptest.NewServer(p)
+}
+
+// Requests返回转发过的请求数目，CONNECT隧道只算一次。
+func (p *Proxy) Requests() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.requests
+}
+
+// ServeHTTP实现http.Handler接口。
+func (p *Proxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	p.requests++
+	p.mutex.Unlock()
+
+	if r.Method == "CONNECT" {
+		p.tunnel(rw, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		rw.WriteHeader(400)
+		rw.Write([]byte("这是一个代理，请求地址必须是绝对地址"))
+		return
+	}
+
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Connection")
+	req.Header.Set("Via", ViaHeader)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		rw.WriteHeader(502)
+		rw.Write([]byte(err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+}
+
+// tunnel处理CONNECT请求，在客户端和目标之间原样转发字节。
+func (p *Proxy) tunnel(rw http.ResponseWriter, r *http.Request) {
+	dst, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		rw.WriteHeader(502)
+		return
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		dst.Close()
+		rw.WriteHeader(500)
+		return
+	}
+	src, _, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		return
+	}
+	src.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		io.Copy(dst, src)
+		dst.Close()
+	}()
+	io.Copy(src, dst)
+	src.Close()
+}
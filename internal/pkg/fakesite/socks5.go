@@ -0,0 +1,174 @@
+package fakesite
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Socks5是一个最简的SOCKS5代理，只支持不认证的CONNECT，与Proxy一样用来代替校外部署时用的代理。
+// SOCKS5只转发字节，不能像Proxy那样加上Via头部，因此它记下每条转发连接在本地的地址，假站点的
+// RequireProxy据此判断请求是否经由它。Socks5可以被多个协程并发使用。
+type Socks5 struct {
+	mutex       sync.Mutex
+	connections int
+	forwarded   map[string]bool
+	ln          net.Listener
+}
+
+// NewSocks5新建一个SOCKS5代理。
+func NewSocks5() *Socks5 {
+	return &Socks5{forwarded: map[string]bool{}}
+}
+
+// Start在本地随机端口上启动代理，返回代理地址（形如socks5://127.0.0.1:1080），用完后需要Close。
+func (p *Socks5) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go p.Serve(ln)
+	return "socks5://" + ln.Addr().String(), nil
+}
+
+// Serve在ln上接受连接，直到ln被关闭为止。
+func (p *Socks5) Serve(ln net.Listener) error {
+	p.mutex.Lock()
+	p.ln = ln
+	p.mutex.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+// Close关闭代理，已经建立的隧道不受影响。
+func (p *Socks5) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.ln == nil {
+		return nil
+	}
+	return p.ln.Close()
+}
+
+// Connections返回转发过的连接数目。
+func (p *Socks5) Connections() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.connections
+}
+
+// Forwarded检查remoteAddr是否是一条正在转发的连接在本地的地址，即请求是否经由这个代理。
+func (p *Socks5) Forwarded(remoteAddr string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.forwarded[remoteAddr]
+}
+
+// handle完成一条连接的握手，然后在客户端和目标之间原样转发字节。
+func (p *Socks5) handle(conn net.Conn) {
+	defer conn.Close()
+	target, err := handshake(conn)
+	if err != nil {
+		return
+	}
+	dst, err := net.Dial("tcp", target)
+	if err != nil {
+		// 5表示连接被拒绝。
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer dst.Close()
+
+	local := dst.LocalAddr().String()
+	p.mutex.Lock()
+	p.connections++
+	p.forwarded[local] = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.forwarded, local)
+		p.mutex.Unlock()
+	}()
+
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(dst, conn)
+		dst.Close()
+	}()
+	io.Copy(conn, dst)
+}
+
+// handshake读取客户端的问候和CONNECT请求，返回目标地址。不支持的请求会先回复错误再返回错误。
+func handshake(conn net.Conn) (string, error) {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	if buf[0] != 5 {
+		return "", errors.New("不是SOCKS5")
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		noAuth = noAuth || m == 0
+	}
+	if !noAuth {
+		conn.Write([]byte{5, 0xff})
+		return "", errors.New("只支持不认证")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", err
+	}
+	if buf[1] != 1 {
+		// 7表示不支持的命令。
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("只支持CONNECT")
+	}
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if buf[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", err
+		}
+		name := buf[1 : 1+int(buf[0])]
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		// 8表示不支持的地址类型。
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("不支持的地址类型")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
@@ -0,0 +1,165 @@
+/*
+fingerprint包管理浏览器指纹。一个指纹是一组互相一致的UA、客户端提示（sec-ch-ua等）和请求头部，
+cas系统的HTTP客户端和jksb系统的Chrome都用同一个指纹，看起来就像同一个浏览器。内嵌了几个
+常见的Chrome指纹，也可以从JSON文件加载更多的指纹，不必重新编译。
+*/
+package fingerprint
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default是默认指纹的名字，与以前写死的伪造头部一致。
+const Default = "chrome99-linux"
+
+// Rotate是一种特殊的指纹名字，表示在所有指纹中按用户名固定地挑一个，不同用户的指纹不同，
+// 同一用户每次的指纹相同。
+const Rotate = "rotate"
+
+//go:embed default.json
+var defaultFingerprints []byte
+
+// Brand是sec-ch-ua中的一个品牌及其主版本号。
+type Brand struct {
+	Brand   string `json:"brand"`
+	Version string `json:"version"`
+}
+
+// Fingerprint是一个浏览器指纹。Headers是除UA、Accept-Language和客户端提示以外的其他请求头部，
+// 客户端提示由Brands、Platform和Mobile生成，保证与Chrome发出的一致。
+type Fingerprint struct {
+	Name           string            `json:"name"`
+	UserAgent      string            `json:"userAgent"`
+	Brands         []Brand           `json:"brands"`
+	Platform       string            `json:"platform"`
+	Mobile         bool              `json:"mobile"`
+	AcceptLanguage string            `json:"acceptLanguage"`
+	Headers        map[string]string `json:"headers"`
+}
+
+// SecChUa返回sec-ch-ua头部的值，形如"Chromium";v="118", "Google Chrome";v="118"。
+func (f *Fingerprint) SecChUa() string {
+	brands := make([]string, len(f.Brands))
+	for i, b := range f.Brands {
+		brands[i] = fmt.Sprintf("%q;v=%q", b.Brand, b.Version)
+	}
+	return strings.Join(brands, ", ")
+}
+
+// Header返回HTTP请求应带上的全部伪造头部，包括UA、Accept-Language和客户端提示。
+func (f *Fingerprint) Header() map[string]string {
+	ret := map[string]string{}
+	for k, v := range f.Headers {
+		ret[k] = v
+	}
+	ret["User-Agent"] = f.UserAgent
+	ret["Accept-Language"] = f.AcceptLanguage
+	ret["sec-ch-ua"] = f.SecChUa()
+	ret["sec-ch-ua-mobile"] = "?0"
+	if f.Mobile {
+		ret["sec-ch-ua-mobile"] = "?1"
+	}
+	ret["sec-ch-ua-platform"] = fmt.Sprintf("%q", f.Platform)
+	return ret
+}
+
+// Check检查指纹是否完整。
+func (f *Fingerprint) Check() error {
+	if f.Name == "" || f.Name == Rotate {
+		return fmt.Errorf("指纹的name不能为空或%s", Rotate)
+	}
+	if f.UserAgent == "" || len(f.Brands) == 0 || f.Platform == "" || f.AcceptLanguage == "" {
+		return fmt.Errorf("指纹%s缺少userAgent、brands、platform或acceptLanguage", f.Name)
+	}
+	return nil
+}
+
+var fingerprints map[string]*Fingerprint
+var fingerprintsMutex sync.RWMutex
+
+func init() {
+	list, err := parse(defaultFingerprints)
+	if err != nil {
+		panic(err)
+	}
+	fingerprints = map[string]*Fingerprint{}
+	for _, f := range list {
+		fingerprints[f.Name] = f
+	}
+}
+
+// parse解析JSON数组形式的指纹，并逐个检查。
+func parse(data []byte) ([]*Fingerprint, error) {
+	list := []*Fingerprint{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, f := range list {
+		if err := f.Check(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// LoadFile从JSON文件加载指纹，文件内容为指纹的数组。与已有指纹同名的会覆盖已有的。
+func LoadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	list, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s：%s", filename, err.Error())
+	}
+	fingerprintsMutex.Lock()
+	defer fingerprintsMutex.Unlock()
+	for _, f := range list {
+		fingerprints[f.Name] = f
+	}
+	return nil
+}
+
+// Names返回按字典序排好的所有指纹的名字。
+func Names() []string {
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	return sortedNames()
+}
+
+// sortedNames返回排好序的所有指纹的名字，调用时需要持有锁。
+func sortedNames() []string {
+	ret := make([]string, 0, len(fingerprints))
+	for name := range fingerprints {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Get返回给定名字的指纹，名字为空时返回默认指纹，为Rotate时按key挑一个。
+func Get(name, key string) (*Fingerprint, error) {
+	if name == "" {
+		name = Default
+	}
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	if name == Rotate {
+		names := sortedNames()
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		name = names[h.Sum32()%uint32(len(names))]
+	}
+	f, ok := fingerprints[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的指纹：%s", name)
+	}
+	return f, nil
+}
@@ -0,0 +1,134 @@
+package jksb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// readFieldsScript读出申报表中所有有名字的输入框、下拉框的当前值，单选框和复选框取选中的那个。
+const readFieldsScript = `(() => {
+  const ret = {};
+  for (const el of document.querySelectorAll("input[name], select[name], textarea[name]")) {
+    if (["hidden", "button", "submit", "reset", "image", "file"].includes(el.type)) {
+      continue;
+    }
+    if (el.type === "radio" || el.type === "checkbox") {
+      if (el.checked) {
+        ret[el.name] = el.value;
+      } else if (!(el.name in ret)) {
+        ret[el.name] = "";
+      }
+      continue;
+    }
+    ret[el.name] = el.value;
+  }
+  return ret;
+})()`
+
+// fillFieldsScript把字段设为给定的值，并触发input和change事件，让infoplus的联动逻辑生效。
+// 先检查所有字段：有页面上找不到的字段，或者下拉框没有值为给定值的选项时（直接赋值会让下拉框
+// 变成空的），不修改任何字段，返回这些字段的名字。%s为字段名到值的JSON。
+const fillFieldsScript = `((fields) => {
+  const ret = { missing: [], invalid: [] };
+  for (const [name, value] of Object.entries(fields)) {
+    const els = document.querySelectorAll('[name="' + CSS.escape(name) + '"]');
+    if (els.length === 0) {
+      ret.missing.push(name);
+      continue;
+    }
+    for (const el of els) {
+      if (el.tagName === "SELECT" && !Array.from(el.options).some((o) => o.value === value)) {
+        ret.invalid.push(name);
+        break;
+      }
+    }
+  }
+  if (ret.missing.length > 0 || ret.invalid.length > 0) {
+    return ret;
+  }
+  for (const [name, value] of Object.entries(fields)) {
+    for (const el of document.querySelectorAll('[name="' + CSS.escape(name) + '"]')) {
+      if (el.type === "radio" || el.type === "checkbox") {
+        if (el.checked !== (el.value === value)) {
+          el.click();
+        }
+        continue;
+      }
+      el.focus();
+      el.value = value;
+      el.dispatchEvent(new Event("input", { bubbles: true }));
+      el.dispatchEvent(new Event("change", { bubbles: true }));
+      el.blur();
+    }
+  }
+  return ret;
+})(%s)`
+
+// fillResult是fillFieldsScript的返回值。
+type fillResult struct {
+	Missing []string `json:"missing"`
+	Invalid []string `json:"invalid"`
+}
+
+// err在有字段不能填写时返回错误，fields是要填写的字段。
+func (r fillResult) err(fields map[string]string) error {
+	if len(r.Missing) > 0 {
+		sort.Strings(r.Missing)
+		return fmt.Errorf("申报表中没有字段%s", strings.Join(r.Missing, "、"))
+	}
+	if len(r.Invalid) > 0 {
+		sort.Strings(r.Invalid)
+		values := make([]string, len(r.Invalid))
+		for i, name := range r.Invalid {
+			values[i] = fmt.Sprintf("%s=%q", name, fields[name])
+		}
+		return fmt.Errorf("申报表的下拉框没有这样的选项：%s，表单可能改版了", strings.Join(values, "、"))
+	}
+	return nil
+}
+
+// Fields返回申报表当前所有字段的值，键为字段名（infoplus中形如fieldXXX）。需要在NextStep之后
+// 调用，此时申报表已经预填了上次申报的值。
+func (s *Session) Fields() (map[string]string, error) {
+	ret := map[string]string{}
+	if err := chromedp.Run(s.timeoutCtx, chromedp.Evaluate(readFieldsScript, &ret)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// FillFields把申报表中的字段改为fields中的值，需要在NextStep之后、Submit之前调用。有字段在页面上
+// 找不到，或者下拉框没有要填的选项时，不会修改任何字段，并返回错误，以免提交一份只改了一半的申报表。
+func (s *Session) FillFields(fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	current, err := s.Fields()
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for name := range fields {
+		if _, ok := current[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("申报表中没有字段%s，表单可能改版了", strings.Join(missing, "、"))
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	r := fillResult{}
+	if err := chromedp.Run(s.timeoutCtx, chromedp.Evaluate(fmt.Sprintf(fillFieldsScript, data), &r)); err != nil {
+		return err
+	}
+	return r.err(fields)
+}
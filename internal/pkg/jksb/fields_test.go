@@ -0,0 +1,22 @@
+package jksb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFillResultErr(t *testing.T) {
+	fields := map[string]string{"fieldA": "3", "fieldB": "y"}
+	if err := (fillResult{}).err(fields); err != nil {
+		t.Errorf("所有字段都能填写时返回了%v", err)
+	}
+	err := fillResult{Missing: []string{"fieldC"}}.err(fields)
+	if err == nil || !strings.Contains(err.Error(), "fieldC") {
+		t.Errorf("有找不到的字段时返回%v", err)
+	}
+	// 下拉框没有要填的选项时必须报错，不能当作填好了。
+	err = fillResult{Invalid: []string{"fieldA"}}.err(fields)
+	if err == nil || !strings.Contains(err.Error(), `fieldA="3"`) {
+		t.Errorf("下拉框没有要填的选项时返回%v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package jksb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// harRecorder记录会话中的网络事件，最后导出为HAR 1.2格式，可以直接用Chrome开发者工具打开。
+// 事件来自chromedp的事件协程，因此需要加锁。
+type harRecorder struct {
+	mutex   sync.Mutex
+	entries []*harEntry
+	pending map[network.RequestID]*harEntry
+}
+
+// harEntry是HAR中的一个请求。
+type harEntry struct {
+	started  time.Time
+	finished time.Time
+	request  *network.Request
+	response *network.Response
+	size     float64
+	errText  string
+}
+
+func newHarRecorder() *harRecorder {
+	return &harRecorder{pending: map[network.RequestID]*harEntry{}}
+}
+
+// handle处理一个网络事件，不是网络事件的忽略。
+func (r *harRecorder) handle(v interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+
+	switch ev := v.(type) {
+	case *network.EventRequestWillBeSent:
+		// 重定向时RequestID不变，先结束上一个请求。
+		if prev, ok := r.pending[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			prev.response = ev.RedirectResponse
+			prev.finished = now
+			delete(r.pending, ev.RequestID)
+		}
+		e := &harEntry{started: now, request: ev.Request}
+		if ev.WallTime != nil {
+			e.started = ev.WallTime.Time()
+		}
+		r.entries = append(r.entries, e)
+		r.pending[ev.RequestID] = e
+	case *network.EventResponseReceived:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.response = ev.Response
+		}
+	case *network.EventLoadingFinished:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.size = ev.EncodedDataLength
+			e.finished = now
+			delete(r.pending, ev.RequestID)
+		}
+	case *network.EventLoadingFailed:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.errText = ev.ErrorText
+			e.finished = now
+			delete(r.pending, ev.RequestID)
+		}
+	}
+}
+
+// harNameValue是HAR中的头部、查询参数等。
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// export导出目前记录的所有请求，还没有结束的请求也会导出，其响应为空。
+func (r *harRecorder) export() ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entries := make([]map[string]interface{}, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e.toHar())
+	}
+	har := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": map[string]string{"name": "jksbx", "version": "1"},
+			"entries": entries,
+		},
+	}
+	return json.MarshalIndent(har, "", "  ")
+}
+
+// toHar把一个请求转为HAR的entry。
+func (e *harEntry) toHar() map[string]interface{} {
+	elapsed := -1.0
+	if !e.finished.IsZero() {
+		elapsed = float64(e.finished.Sub(e.started).Milliseconds())
+	}
+
+	query := []harNameValue{}
+	if u, err := url.Parse(e.request.URL); err == nil {
+		for k, vs := range u.Query() {
+			for _, v := range vs {
+				query = append(query, harNameValue{k, v})
+			}
+		}
+	}
+	request := map[string]interface{}{
+		"method":      e.request.Method,
+		"url":         e.request.URL,
+		"httpVersion": "HTTP/1.1",
+		"headers":     harHeaders(e.request.Headers),
+		"queryString": query,
+		"cookies":     []harNameValue{},
+		"headersSize": -1,
+		"bodySize":    len(e.request.PostData),
+	}
+	if e.request.HasPostData {
+		request["postData"] = map[string]string{
+			"mimeType": fmt.Sprint(e.request.Headers["Content-Type"]),
+			"text":     e.request.PostData,
+		}
+	}
+
+	response := map[string]interface{}{
+		"status":      0,
+		"statusText":  e.errText,
+		"httpVersion": "",
+		"headers":     []harNameValue{},
+		"cookies":     []harNameValue{},
+		"content":     map[string]interface{}{"size": 0, "mimeType": ""},
+		"redirectURL": "",
+		"headersSize": -1,
+		"bodySize":    -1,
+	}
+	if e.response != nil {
+		response["status"] = e.response.Status
+		response["statusText"] = e.response.StatusText
+		response["httpVersion"] = e.response.Protocol
+		response["headers"] = harHeaders(e.response.Headers)
+		response["content"] = map[string]interface{}{"size": int64(e.size), "mimeType": e.response.MimeType}
+		response["redirectURL"] = fmt.Sprint(e.response.Headers["Location"])
+		if e.response.Headers["Location"] == nil {
+			response["redirectURL"] = ""
+		}
+		response["bodySize"] = int64(e.size)
+	}
+
+	ret := map[string]interface{}{
+		"startedDateTime": e.started.Format(time.RFC3339Nano),
+		"time":            elapsed,
+		"request":         request,
+		"response":        response,
+		"cache":           map[string]interface{}{},
+		"timings":         map[string]float64{"send": 0, "wait": elapsed, "receive": 0},
+	}
+	if e.errText != "" {
+		ret["_error"] = e.errText
+	}
+	return ret
+}
+
+// harHeaders把Chrome的头部转为HAR的头部，按名字排序。
+func harHeaders(h network.Headers) []harNameValue {
+	ret := make([]harNameValue, 0, len(h))
+	for k, v := range h {
+		ret = append(ret, harNameValue{k, fmt.Sprint(v)})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
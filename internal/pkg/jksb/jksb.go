@@ -3,105 +3,269 @@ package jksb
 import (
 	"context"
 	_ "embed"
+	"fmt"
+	"jksbx/internal/pkg/fingerprint"
+	"jksbx/internal/pkg/proxy"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/chromedp/cdproto/emulation"
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
 
-const (
-	JKSB_LOGIN_URL = "https://cas.sysu.edu.cn/cas/login?service=http://jksb.sysu.edu.cn/infoplus/login?retUrl=http://jksb.sysu.edu.cn/infoplus/form/XNYQSB/start"
-)
+// Config是一个jksb系统（infoplus）的配置。LoginUrl是经cas系统登录、再跳转到申报表页面的地址；
+// Cookie*是cas系统登录态cookie所在的域名和路径；SubmitSelector是“下一步”和“提交”按钮的
+// CSS选择器；FormSelector是只在填写申报表的一步才有的元素（比如申报表的字段）的CSS选择器，
+// 用来确认点击“下一步”之后第二步已经加载好了，忽略则为[name^="field"]；*Posts是申报表页面加载完、点击“下一步”、点击“提交”后预计会发出的POST请求数目，
+// 只用来判断网络空闲，实际的数目多一个少一个都不要紧。QuietPeriod是网络安静多少毫秒才算空闲，
+// WaitTimeout是每次等待的最长秒数，为0时使用默认值。
+type Config struct {
+	LoginUrl       string `json:"loginUrl"`
+	CookieDomain   string `json:"cookieDomain"`
+	CookiePath     string `json:"cookiePath"`
+	CookieSecure   bool   `json:"cookieSecure"`
+	SubmitSelector string `json:"submitSelector"`
+	FormSelector   string `json:"formSelector,omitempty"`
+	LoadPosts      int    `json:"loadPosts"`
+	NextPosts      int    `json:"nextPosts"`
+	SubmitPosts    int    `json:"submitPosts"`
+	QuietPeriod    int    `json:"quietPeriod,omitempty"`
+	WaitTimeout    int    `json:"waitTimeout,omitempty"`
+}
+
+// Check检查配置是否完整。
+func (c *Config) Check() error {
+	if c.LoginUrl == "" || c.CookieDomain == "" || c.SubmitSelector == "" {
+		return fmt.Errorf("jksb系统的配置缺少loginUrl、cookieDomain或submitSelector")
+	}
+	if c.LoadPosts < 0 || c.NextPosts < 0 || c.SubmitPosts < 0 || c.QuietPeriod < 0 || c.WaitTimeout < 0 {
+		return fmt.Errorf("jksb系统的配置中POST请求数目、安静时间和等待时间不能为负数")
+	}
+	return nil
+}
 
 //go:embed bypass.js
 var bypassScript string
 
+// Session是jksb系统上的一个会话，对应一个Chrome浏览器，用完后需要Close。
 type Session struct {
+	config        Config
+	fingerprint   *fingerprint.Fingerprint
 	timeoutCtx    context.Context
 	timeoutCancel context.CancelFunc
 	allocCtx      context.Context
+	allocCancel   context.CancelFunc
 	clientCtx     context.Context
+	clientCancel  context.CancelFunc
+	har           *harRecorder
+	net           *netWatcher
+	script        *Script
+	recorder      *recorder
 
-	numRemainedPosts int
-	postSet          map[network.RequestID]struct{}
-	waitingDone      chan struct{}
+	// mutex保护下面这些由事件协程写入的状态。
+	mutex          sync.Mutex
+	docStatus      int64
+	doActionId     network.RequestID
+	doActionStatus int64
+	doActionFailed string
 }
 
-// NewSession新建一个新的会话，需要指定超时、浏览器UA、以及是否要显示浏览器窗口。
-func NewSession(timeout time.Duration, ua string, headful bool) *Session {
-	ret := &Session{}
-	ret.timeoutCtx, ret.timeoutCancel = context.WithTimeout(context.Background(), timeout)
+// Trace是会话失败时留下的现场：整个页面的截图（PNG）、页面的DOM和会话中所有网络请求的HAR。
+type Trace struct {
+	Screenshot []byte
+	DOM        string
+	HAR        []byte
+}
+
+// NewSession在c所指的jksb系统上新建一个新的会话，需要指定超时、浏览器指纹、代理（nil表示不用）、以及
+// 是否要显示浏览器窗口。指纹和代理应该与登录cas时的一致。
+func NewSession(c Config, timeout time.Duration, fp *fingerprint.Fingerprint, proxyUrl *url.URL, headful bool) *Session {
+	ret := &Session{config: c, fingerprint: fp, net: newNetWatcher(), script: DefaultScript}
 
 	opts := append(
 		chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("enable-automation", false),
 		chromedp.Flag("disable-blink-features", "AutomationControlled"),
-		chromedp.UserAgent(ua),
+		chromedp.UserAgent(fp.UserAgent),
+		chromedp.Flag("lang", strings.Split(fp.AcceptLanguage, ",")[0]),
 	)
 	if headful {
 		opts = append(opts, chromedp.Flag("headless", false))
 	}
+	for k, v := range proxy.ChromeFlags(proxyUrl) {
+		opts = append(opts, chromedp.Flag(k, v))
+	}
 
-	ret.allocCtx, _ = chromedp.NewExecAllocator(ret.timeoutCtx, opts...)
-	ret.clientCtx, _ = chromedp.NewContext(ret.allocCtx)
-
-	ret.postSet = map[network.RequestID]struct{}{}
-	ret.waitingDone = make(chan struct{})
+	// 浏览器的生命周期与超时分开，超时之后浏览器仍然活着，还可以用Capture留下现场。
+	ret.allocCtx, ret.allocCancel = chromedp.NewExecAllocator(context.Background(), opts...)
+	ret.clientCtx, ret.clientCancel = chromedp.NewContext(ret.allocCtx)
+	ret.timeoutCtx, ret.timeoutCancel = context.WithTimeout(ret.clientCtx, timeout)
 
-	// 注册网络监听函数。
+	// 注册网络监听函数，它们在chromedp的事件协程中运行，各自加锁，不能阻塞。
 	chromedp.ListenTarget(ret.clientCtx, func(v interface{}) {
-		switch ev := v.(type) {
-		case *network.EventRequestWillBeSent:
-			if ev.Request.Method == "POST" {
-				ret.postSet[ev.RequestID] = struct{}{}
-			}
-		case *network.EventLoadingFailed:
-			if _, ok := ret.postSet[ev.RequestID]; ok {
-				ret.numRemainedPosts--
-				if ret.numRemainedPosts == 0 {
-					ret.waitingDone <- struct{}{}
-				}
-			}
-		case *network.EventLoadingFinished:
-			if _, ok := ret.postSet[ev.RequestID]; ok {
-				ret.numRemainedPosts--
-				if ret.numRemainedPosts == 0 {
-					ret.waitingDone <- struct{}{}
-				}
-			}
+		if ret.har != nil {
+			ret.har.handle(v)
+		}
+		ret.recordOutcomeEvent(v)
+		ret.net.handle(v)
+		if ret.recorder != nil {
+			ret.recorder.handle(v)
 		}
 	})
 
 	return ret
 }
 
-// LoginJksb用TGC和JSESSIONID登入jksb系统，注意fakeHeader应该与登录cas时的一致。
-func (s *Session) LoginJksb(tgc, jsessionid *http.Cookie, fakeHeader map[string]string) error {
+// recordOutcomeEvent记录判断结果所需的事件：页面本身的状态码，以及doAction请求。
+func (s *Session) recordOutcomeEvent(v interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch ev := v.(type) {
+	case *network.EventRequestWillBeSent:
+		if ev.Request.Method == "POST" && strings.Contains(ev.Request.URL, doActionPath) {
+			s.doActionId = ev.RequestID
+		}
+	case *network.EventResponseReceived:
+		if ev.Type == network.ResourceTypeDocument {
+			s.docStatus = ev.Response.Status
+		}
+		if ev.RequestID == s.doActionId {
+			s.doActionStatus = ev.Response.Status
+		}
+	case *network.EventLoadingFailed:
+		if ev.RequestID == s.doActionId {
+			s.doActionFailed = ev.ErrorText
+		}
+	}
+}
+
+// checkOffline在登录jksb系统失败时检查系统是否下线了，是则把err包装为ErrOffline。
+func (s *Session) checkOffline(err error) error {
+	s.mutex.Lock()
+	status := s.docStatus
+	s.mutex.Unlock()
+	if status >= 500 {
+		return fmt.Errorf("%w：页面返回%d，%s", ErrOffline, status, err.Error())
+	}
+	ctx, cancel := context.WithTimeout(s.clientCtx, 10*time.Second)
+	defer cancel()
+	if text, _, perr := s.pageState(ctx); perr == nil && containsAny(text, offlineKeywords) {
+		return fmt.Errorf("%w：%s", ErrOffline, err.Error())
+	}
+	return err
+}
+
+// SetScript指定申报的流程，不调用时用DefaultScript。需要在LoginJksb之前调用。
+func (s *Session) SetScript(sc *Script) {
+	s.script = sc
+}
+
+// EnableTrace开始记录会话中的网络请求，失败时可以用Capture导出。需要在LoginJksb之前调用。
+func (s *Session) EnableTrace() {
+	s.har = newHarRecorder()
+}
+
+// Capture留下会话当前的现场，即使会话已经超时也可以调用，但必须在Close之前。没有调用
+// EnableTrace时Trace中没有HAR。
+func (s *Session) Capture() (*Trace, error) {
+	ctx, cancel := context.WithTimeout(s.clientCtx, 20*time.Second)
+	defer cancel()
+
+	t := &Trace{}
+	if s.har != nil {
+		har, err := s.har.export()
+		if err != nil {
+			return nil, err
+		}
+		t.HAR = har
+	}
+	err := chromedp.Run(ctx,
+		chromedp.FullScreenshot(&t.Screenshot, 100),
+		chromedp.OuterHTML("html", &t.DOM, chromedp.ByQuery),
+	)
+	if err != nil {
+		return t, fmt.Errorf("截图或导出DOM失败：%s", err.Error())
+	}
+	return t, nil
+}
+
+// Close关闭浏览器，结束会话。
+func (s *Session) Close() {
+	s.timeoutCancel()
+	s.clientCancel()
+	s.allocCancel()
+}
+
+// LoginJksb用TGC和JSESSIONID登入jksb系统。
+func (s *Session) LoginJksb(tgc, jsessionid *http.Cookie) error {
 	temFakeHeader := map[string]interface{}{}
-	for k, v := range fakeHeader {
+	for k, v := range s.fingerprint.Header() {
 		temFakeHeader[k] = v
 	}
 
-	// 页面加载好之后再模拟点击。
-	s.numRemainedPosts = 3
-	err := chromedp.Run(s.clientCtx,
+	// 先启动浏览器，第一次Run所用的context结束时浏览器会被关闭，因此不能用带超时的。
+	if err := chromedp.Run(s.clientCtx); err != nil {
+		return err
+	}
+
+	// 伪装好浏览器、带上登录态之后，按流程打开申报表，页面加载好之后再模拟点击。
+	err := chromedp.Run(s.timeoutCtx,
 		network.SetExtraHTTPHeaders(network.Headers(temFakeHeader)),
+		chromedp.ActionFunc(s.overrideUserAgent),
 		chromedp.ActionFunc(bypassAction),
-		setCookie(tgc.Name, tgc.Value, "cas.sysu.edu.cn", "/cas/", true, true),
-		setCookie(jsessionid.Name, jsessionid.Value, "cas.sysu.edu.cn", "/cas", true, false),
-		chromedp.Navigate(JKSB_LOGIN_URL),
+		setCookie(tgc.Name, tgc.Value, s.config.CookieDomain, s.config.CookiePath+"/", true, s.config.CookieSecure),
+		setCookie(jsessionid.Name, jsessionid.Value, s.config.CookieDomain, s.config.CookiePath, true, false),
 	)
+	if err == nil && s.recorder != nil {
+		err = chromedp.Run(s.timeoutCtx, chromedp.ActionFunc(s.recorder.install))
+	}
+	if err == nil {
+		err = s.runPhase("加载申报表", s.script.Load, s.config.LoadPosts)
+	}
 	if err != nil {
-		return err
+		return s.checkOffline(err)
 	}
 
-	<-s.waitingDone
-
+	s.mutex.Lock()
+	status := s.docStatus
+	s.mutex.Unlock()
+	if status >= 500 {
+		return fmt.Errorf("%w：页面返回%d", ErrOffline, status)
+	}
 	return nil
 }
 
+// overrideUserAgent让Chrome的UA、navigator.platform和客户端提示都与指纹一致。
+func (s *Session) overrideUserAgent(ctx context.Context) error {
+	fp := s.fingerprint
+	brands := make([]*emulation.UserAgentBrandVersion, len(fp.Brands))
+	for i, b := range fp.Brands {
+		brands[i] = &emulation.UserAgentBrandVersion{Brand: b.Brand, Version: b.Version}
+	}
+	return emulation.SetUserAgentOverride(fp.UserAgent).
+		WithAcceptLanguage(fp.AcceptLanguage).
+		WithPlatform(navigatorPlatforms[fp.Platform]).
+		WithUserAgentMetadata(&emulation.UserAgentMetadata{
+			Brands:   brands,
+			Platform: fp.Platform,
+			Mobile:   fp.Mobile,
+		}).
+		Do(ctx)
+}
+
+// navigatorPlatforms是客户端提示中的平台对应的navigator.platform。
+var navigatorPlatforms = map[string]string{
+	"Windows": "Win32",
+	"macOS":   "MacIntel",
+	"Linux":   "Linux x86_64",
+	"Android": "Linux armv8l",
+}
+
 func setCookie(name, value, domain, path string, httpOnly, secure bool) chromedp.Action {
 	return chromedp.ActionFunc(func(ctx context.Context) error {
 		return network.SetCookie(name, value).
@@ -114,36 +278,86 @@ func setCookie(name, value, domain, path string, httpOnly, secure bool) chromedp
 }
 
 // SubmitJksb将试图模拟提交申报表操作，注意此时会话必须处于申报表填写页面，正常情况下，LoginJksb成功后，
-// 页面就处于申报表填写页面。
-func (s *Session) SubmitJksb() error {
-	// 后面模拟点击“下一步”后，开始监听POST请求的数量，完成了一定次数后代表第二步的页面
-	// 已经加载好，可以进行后续操作。
-	s.numRemainedPosts = 4
-
-	// 提交申报表的第一步（阅读相关信息）。
-	err := chromedp.Run(s.clientCtx,
-		chromedp.Click(`#form_command_bar > li:first-child > a`, chromedp.ByQuery),
-	)
+// 页面就处于申报表填写页面。fields不为空时，提交前先把这些字段改为给定的值。提交之后检查doAction的
+// 响应或者页面，返回提交的结果；无法判断结果时返回错误。
+func (s *Session) SubmitJksb(fields map[string]string) (*Result, error) {
+	r, err := s.NextStep()
+	if r != nil || err != nil {
+		return r, err
+	}
+	if err := s.FillFields(fields); err != nil {
+		return nil, err
+	}
+	return s.Submit()
+}
+
+// NextStep点击“下一步”，进入填写申报表的一步，之后可以用Fields和FillFields查看、修改字段。
+// 打开申报表时就已经申报过了、系统下线了或者检查不通过时，返回相应的结果。
+func (s *Session) NextStep() (*Result, error) {
+	// 打开申报表时，可能就已经弹出对话框说今天申报过了，或者系统正在维护。这时还没有点击，
+	// 只看对话框，不看正文。
+	_, dialog, err := s.pageState(s.timeoutCtx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if r := classifyDialog(dialog); r != nil {
+		return r, nil
 	}
-	<-s.waitingDone
 
-	// 后面模拟“提交”后，再次阻塞，等待指定次数POST请求结束后，代表表单提交完成，可以关闭浏览器了。
-	s.numRemainedPosts = 2
+	// 提交申报表的第一步（阅读相关信息），按流程点击“下一步”，等第二步的页面加载好之后，
+	// 可以进行后续操作。
+	if err := s.runPhase("下一步", s.script.Next, s.config.NextPosts); err != nil {
+		if r := s.inspectPage(); r != nil && r.Outcome != Submitted {
+			return r, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
 
-	// 已经加载好第二步，这里模拟点击“提交”。
-	err = chromedp.Run(s.clientCtx,
-		chromedp.Click(`#form_command_bar > li:first-child > a`, chromedp.ByQuery),
-	)
-	if err != nil {
-		return err
+// Submit点击“提交”，需要在NextStep之后调用。提交之后检查doAction的响应或者页面，返回提交的结果；
+// 无法判断结果时返回错误。
+func (s *Session) Submit() (*Result, error) {
+	s.mutex.Lock()
+	s.doActionId, s.doActionStatus, s.doActionFailed = "", 0, ""
+	s.mutex.Unlock()
+
+	// 已经加载好第二步，这里按流程模拟点击“提交”。发出了doAction时，还要等它的响应结束才能读取。
+	m := s.net.mark()
+	waitErr := s.runPhase("提交", s.script.Submit, s.config.SubmitPosts)
+	s.mutex.Lock()
+	sent := s.doActionId != ""
+	s.mutex.Unlock()
+	if sent {
+		if err := s.waitResponse("提交", m, doActionPath); err != nil && waitErr == nil {
+			waitErr = err
+		}
 	}
-	<-s.waitingDone
 
-	s.timeoutCancel()
+	// 优先看doAction的响应，没有发出doAction时（比如检查不通过）再看页面。
+	ctx, cancel := context.WithTimeout(s.clientCtx, 10*time.Second)
+	defer cancel()
+	if r := s.doActionResult(ctx); r != nil {
+		return r, nil
+	}
+	if r := s.inspectPage(); r != nil {
+		return r, nil
+	}
+	if waitErr != nil {
+		return nil, waitErr
+	}
+	return nil, fmt.Errorf("点击提交后没有发出doAction请求，页面上也没有提示，无法确认是否提交成功")
+}
 
-	return nil
+// inspectPage根据当前页面判断结果，即使会话已经超时也可以调用，判断不出时返回nil。
+func (s *Session) inspectPage() *Result {
+	ctx, cancel := context.WithTimeout(s.clientCtx, 10*time.Second)
+	defer cancel()
+	text, dialog, err := s.pageState(ctx)
+	if err != nil {
+		return nil
+	}
+	return classifyPage(text, dialog)
 }
 
 func bypassAction(ctx context.Context) error {
@@ -0,0 +1,181 @@
+package jksb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrOffline表示jksb系统下线了，比如每天凌晨的维护时间，或者服务器返回5xx。
+var ErrOffline = errors.New("jksb系统下线了")
+
+// Outcome是提交申报表的结果。
+type Outcome int
+
+const (
+	// Submitted表示提交成功。
+	Submitted Outcome = iota
+	// AlreadySubmitted表示今天已经申报过了，不需要再提交。
+	AlreadySubmitted
+	// ValidationFailed表示申报表没有通过检查，Result.Message是页面或doAction给出的原因。
+	ValidationFailed
+	// Offline表示jksb系统下线了。
+	Offline
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Submitted:
+		return "提交成功"
+	case AlreadySubmitted:
+		return "今天已经申报过了"
+	case ValidationFailed:
+		return "申报表没有通过检查"
+	case Offline:
+		return "jksb系统下线了"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Result是提交申报表的结果及其说明。
+type Result struct {
+	Outcome Outcome
+	Message string
+}
+
+func (r *Result) String() string {
+	if r.Message == "" {
+		return r.Outcome.String()
+	}
+	return fmt.Sprintf("%s：%s", r.Outcome.String(), r.Message)
+}
+
+// 页面文字或doAction的错误信息中，表示各种结果的关键词。
+var (
+	submittedKeywords        = []string{"办理成功", "提交成功", "申报成功"}
+	alreadySubmittedKeywords = []string{"已经提交", "已提交", "已申报", "已经申报", "已填报", "已经填报", "重复提交", "重复申报"}
+	offlineKeywords          = []string{"系统维护", "暂停服务", "系统繁忙", "Service Unavailable", "Bad Gateway", "Gateway Time-out"}
+)
+
+// containsAny判断s是否含有keywords中的任何一个。
+func containsAny(s string, keywords []string) bool {
+	for _, k := range keywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// doActionPath是提交申报表的doAction接口的路径。
+const doActionPath = "/interface/doAction"
+
+// doActionResponse是infoplus的doAction接口返回的JSON。
+type doActionResponse struct {
+	Errno int    `json:"errno"`
+	Ecode string `json:"ecode"`
+	Error string `json:"error"`
+}
+
+// classifyDoAction根据doAction的响应判断提交的结果。
+func classifyDoAction(status int64, body []byte) *Result {
+	if status >= 500 {
+		return &Result{Outcome: Offline, Message: fmt.Sprintf("doAction返回%d", status)}
+	}
+	resp := doActionResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &Result{Outcome: ValidationFailed, Message: "无法解析doAction的响应：" + err.Error()}
+	}
+	if resp.Errno == 0 {
+		return &Result{Outcome: Submitted}
+	}
+	message := resp.Error
+	if message == "" {
+		message = resp.Ecode
+	}
+	if containsAny(message, alreadySubmittedKeywords) {
+		return &Result{Outcome: AlreadySubmitted, Message: message}
+	}
+	if containsAny(message, offlineKeywords) {
+		return &Result{Outcome: Offline, Message: message}
+	}
+	return &Result{Outcome: ValidationFailed, Message: message}
+}
+
+// dialogScript找出页面上可见的对话框或错误提示的文字，infoplus的检查不通过时会弹出对话框。
+const dialogScript = `(() => {
+  const nodes = document.querySelectorAll('[role="dialog"], [class*="dialog"], [class*="messager"], [class*="error"], [class*="alert"]');
+  for (const n of nodes) {
+    if (n.offsetParent !== null && n.innerText.trim() !== "") {
+      return n.innerText.trim();
+    }
+  }
+  return "";
+})()`
+
+// pageState返回页面的文字，以及页面上可见的对话框的文字。
+func (s *Session) pageState(ctx context.Context) (text, dialog string, err error) {
+	err = chromedp.Run(ctx,
+		chromedp.Evaluate(`document.body ? document.body.innerText : ""`, &text),
+		chromedp.Evaluate(dialogScript, &dialog),
+	)
+	return text, dialog, err
+}
+
+// classifyDialog只根据对话框的文字判断申报前的状态：今天已经申报过了，或者系统下线了，判断不出时
+// 返回nil。申报表的正文里本来就可能有“已提交”“已申报”之类的字样，比如栏目说明或往日的记录，
+// 不能据此认为今天已经申报过，否则申报表会被悄悄跳过。
+func classifyDialog(dialog string) *Result {
+	switch {
+	case containsAny(dialog, alreadySubmittedKeywords):
+		return &Result{Outcome: AlreadySubmitted, Message: dialog}
+	case containsAny(dialog, offlineKeywords):
+		return &Result{Outcome: Offline, Message: dialog}
+	}
+	return nil
+}
+
+// classifyPage根据页面的文字判断结果，判断不出时返回nil。今天已经申报过只看对话框，见classifyDialog。
+func classifyPage(text, dialog string) *Result {
+	if r := classifyDialog(dialog); r != nil {
+		return r
+	}
+	switch {
+	case containsAny(text, offlineKeywords):
+		return &Result{Outcome: Offline}
+	case dialog != "" && !containsAny(dialog, submittedKeywords):
+		return &Result{Outcome: ValidationFailed, Message: dialog}
+	case containsAny(text, submittedKeywords) || containsAny(dialog, submittedKeywords):
+		return &Result{Outcome: Submitted}
+	}
+	return nil
+}
+
+// doActionResult返回点击“提交”后doAction请求的结果，没有发出doAction时返回nil。
+func (s *Session) doActionResult(ctx context.Context) *Result {
+	s.mutex.Lock()
+	id, status, failed := s.doActionId, s.doActionStatus, s.doActionFailed
+	s.mutex.Unlock()
+	if id == "" {
+		return nil
+	}
+	if failed != "" {
+		return &Result{Outcome: Offline, Message: "doAction请求失败：" + failed}
+	}
+
+	var body []byte
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetResponseBody(id).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return &Result{Outcome: ValidationFailed, Message: "无法读取doAction的响应：" + err.Error()}
+	}
+	return classifyDoAction(status, body)
+}
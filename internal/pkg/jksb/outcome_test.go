@@ -0,0 +1,44 @@
+package jksb
+
+import "testing"
+
+func TestClassifyDialog(t *testing.T) {
+	tests := []struct {
+		dialog string
+		want   *Result
+	}{
+		{"", nil},
+		{"您今天已经提交过了", &Result{Outcome: AlreadySubmitted, Message: "您今天已经提交过了"}},
+		{"系统维护中，暂停服务", &Result{Outcome: Offline, Message: "系统维护中，暂停服务"}},
+		{"请填写体温", nil},
+	}
+	for _, test := range tests {
+		got := classifyDialog(test.dialog)
+		if (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("classifyDialog(%q)为%v，应为%v", test.dialog, got, test.want)
+		}
+	}
+}
+
+func TestClassifyPage(t *testing.T) {
+	tests := []struct {
+		text, dialog string
+		want         *Result
+	}{
+		// 正文里的“已提交”“已申报”不能当作今天已经申报过。
+		{"本表已提交的记录可在“我的申报”中查看", "", nil},
+		{"已申报人数：1024", "", nil},
+		{"健康申报 下一步", "", nil},
+		{"", "今天已经申报过了", &Result{Outcome: AlreadySubmitted, Message: "今天已经申报过了"}},
+		{"503 Service Unavailable", "", &Result{Outcome: Offline}},
+		{"健康申报", "请填写体温", &Result{Outcome: ValidationFailed, Message: "请填写体温"}},
+		{"办理成功", "", &Result{Outcome: Submitted}},
+		{"健康申报", "提交成功", &Result{Outcome: Submitted}},
+	}
+	for _, test := range tests {
+		got := classifyPage(test.text, test.dialog)
+		if (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("classifyPage(%q, %q)为%v，应为%v", test.text, test.dialog, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package jksb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// recordBinding是页面中用来把用户操作报告给Go的函数名。
+const recordBinding = "jksbxRecord"
+
+// recordScript在每个页面中监听用户的点击和输入，报告给recordBinding。点击了“下一步”或“提交”
+// 按钮（%s为其选择器的JSON）时只报告submit，其他元素报告一个尽量稳定的选择器：优先用id和name，
+// 否则用从body开始的路径。
+const recordScript = `(() => {
+  const submitSelector = %s;
+  const report = (step) => window.` + recordBinding + `(JSON.stringify(step));
+  const selectorOf = (el) => {
+    if (el.id) {
+      return "#" + CSS.escape(el.id);
+    }
+    if (el.name) {
+      return el.tagName.toLowerCase() + "[name=" + JSON.stringify(el.name) + "]";
+    }
+    const parts = [];
+    for (; el && el.nodeType === 1 && el !== document.body; el = el.parentElement) {
+      if (el.id) {
+        parts.unshift("#" + CSS.escape(el.id));
+        break;
+      }
+      let part = el.tagName.toLowerCase();
+      const parent = el.parentElement;
+      if (parent) {
+        const same = Array.from(parent.children).filter((c) => c.tagName === el.tagName);
+        if (same.length > 1) {
+          part += ":nth-of-type(" + (same.indexOf(el) + 1) + ")";
+        }
+      }
+      parts.unshift(part);
+    }
+    return parts.join(" > ");
+  };
+  document.addEventListener("click", (e) => {
+    if (!(e.target instanceof Element)) {
+      return;
+    }
+    if (e.target.closest(submitSelector)) {
+      report({ action: "submit" });
+      return;
+    }
+    // 输入框由change报告，点击标签会再触发一次对应输入框的点击，也交给change。
+    if (e.target.closest("input, select, textarea, label")) {
+      return;
+    }
+    report({ action: "click", selector: selectorOf(e.target) });
+  }, true);
+  document.addEventListener("change", (e) => {
+    const el = e.target;
+    if (!(el instanceof Element) || !el.matches("input, select, textarea")) {
+      return;
+    }
+    if (el.type === "checkbox") {
+      report({ action: "click", selector: selectorOf(el) });
+      return;
+    }
+    // 同一组单选框的name相同，没有id时要带上值，否则回放时点的总是第一个。
+    if (el.type === "radio") {
+      const sel = selectorOf(el);
+      report({ action: "click", selector: el.id ? sel : sel + "[value=" + JSON.stringify(el.value) + "]" });
+      return;
+    }
+    report({ action: "fill", selector: selectorOf(el), value: el.value });
+  }, true);
+})()`
+
+// recorder把有头浏览器中用户的操作记录为流程。第一次点击“下一步”之前的操作归入load阶段，
+// 之后到点击“提交”之前的归入next阶段，其余归入submit阶段。事件来自chromedp的事件协程，因此
+// 需要加锁。
+type recorder struct {
+	submitSelector string
+	mutex          sync.Mutex
+	phase          int
+	steps          [3][]Step
+}
+
+// install在浏览器中注册recordBinding，并让之后打开的每个页面都执行recordScript。
+func (r *recorder) install(ctx context.Context) error {
+	if err := runtime.AddBinding(recordBinding).Do(ctx); err != nil {
+		return err
+	}
+	sel, _ := json.Marshal(r.submitSelector)
+	_, err := page.AddScriptToEvaluateOnNewDocument(fmt.Sprintf(recordScript, sel)).Do(ctx)
+	return err
+}
+
+// handle处理页面报告的一次用户操作，其他事件忽略。
+func (r *recorder) handle(v interface{}) {
+	ev, ok := v.(*runtime.EventBindingCalled)
+	if !ok || ev.Name != recordBinding {
+		return
+	}
+	st := Step{}
+	if err := json.Unmarshal([]byte(ev.Payload), &st); err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	noPosts := 0
+	switch st.Action {
+	case "submit":
+		if r.phase < 2 {
+			r.phase++
+		}
+		r.steps[r.phase] = append(r.steps[r.phase],
+			Step{Action: ActionClick, Selector: "${submitSelector}"},
+			Step{Action: ActionWaitIdle},
+		)
+		if r.phase == 1 {
+			r.steps[r.phase] = append(r.steps[r.phase], Step{Action: ActionWaitVisible, Selector: "${formSelector}"})
+		}
+	case ActionClick:
+		r.steps[r.phase] = append(r.steps[r.phase], st, Step{Action: ActionWaitIdle, Posts: &noPosts})
+	case ActionFill:
+		// 同一个输入框连续改了几次时只保留最后一次。
+		steps := r.steps[r.phase]
+		if n := len(steps); n > 0 && steps[n-1].Action == ActionFill && steps[n-1].Selector == st.Selector {
+			steps[n-1].Value = st.Value
+			return
+		}
+		r.steps[r.phase] = append(steps, st)
+	}
+}
+
+// StartRecording开始记录用户在浏览器中的操作，需要有头浏览器，并且在LoginJksb之前调用。
+// LoginJksb仍然按流程打开申报表，之后由用户手动点击、填写和提交，结束后调用StopRecording。
+func (s *Session) StartRecording() {
+	s.recorder = &recorder{submitSelector: s.config.SubmitSelector}
+}
+
+// StopRecording返回记录下来的流程，load阶段为打开申报表所用的步骤加上用户在点击“下一步”之前的
+// 操作。用户没有点击到“提交”时流程不完整，可以用Check检查。
+func (s *Session) StopRecording() *Script {
+	r := s.recorder
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sc := &Script{
+		Load:   append(append([]Step{}, s.script.Load...), r.steps[0]...),
+		Next:   append([]Step{}, r.steps[1]...),
+		Submit: append([]Step{}, r.steps[2]...),
+	}
+	return sc
+}
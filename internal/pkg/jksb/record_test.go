@@ -0,0 +1,163 @@
+package jksb
+
+import (
+	"jksbx/internal/pkg/fingerprint"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// report模拟页面通过recordBinding报告一次用户操作。
+func report(r *recorder, payload string) {
+	r.handle(&runtime.EventBindingCalled{Name: recordBinding, Payload: payload})
+}
+
+func TestRecorderPhases(t *testing.T) {
+	r := &recorder{submitSelector: "#submit"}
+	report(r, `{"action":"click","selector":"#agree"}`)
+	report(r, `{"action":"submit"}`)
+	report(r, `{"action":"fill","selector":"#tw","value":"36"}`)
+	report(r, `{"action":"fill","selector":"#tw","value":"36.5"}`)
+	r.handle(&runtime.EventBindingCalled{Name: "other", Payload: `{"action":"submit"}`})
+	report(r, `not json`)
+	report(r, `{"action":"submit"}`)
+	report(r, `{"action":"submit"}`)
+
+	s := &Session{script: DefaultScript, recorder: r}
+	sc := s.StopRecording()
+	noPosts := 0
+	wantLoad := append(append([]Step{}, DefaultScript.Load...),
+		Step{Action: ActionClick, Selector: "#agree"}, Step{Action: ActionWaitIdle, Posts: &noPosts})
+	if !reflect.DeepEqual(sc.Load, wantLoad) {
+		t.Errorf("load阶段为%+v，应为%+v", sc.Load, wantLoad)
+	}
+	// 第一次点击“下一步”之后等第二步才有的元素，同一个输入框连续改了几次只保留最后一次。
+	wantNext := []Step{
+		{Action: ActionClick, Selector: "${submitSelector}"},
+		{Action: ActionWaitIdle},
+		{Action: ActionWaitVisible, Selector: "${formSelector}"},
+		{Action: ActionFill, Selector: "#tw", Value: "36.5"},
+	}
+	if !reflect.DeepEqual(sc.Next, wantNext) {
+		t.Errorf("next阶段为%+v，应为%+v", sc.Next, wantNext)
+	}
+	// 点击了两次“提交”时都归入submit阶段。
+	wantSubmit := []Step{
+		{Action: ActionClick, Selector: "${submitSelector}"},
+		{Action: ActionWaitIdle},
+		{Action: ActionClick, Selector: "${submitSelector}"},
+		{Action: ActionWaitIdle},
+	}
+	if !reflect.DeepEqual(sc.Submit, wantSubmit) {
+		t.Errorf("submit阶段为%+v，应为%+v", sc.Submit, wantSubmit)
+	}
+	if err := sc.Check(); err != nil {
+		t.Errorf("完整的录制检查不通过：%s", err.Error())
+	}
+}
+
+func TestRecorderIncomplete(t *testing.T) {
+	r := &recorder{submitSelector: "#submit"}
+	report(r, `{"action":"submit"}`)
+	sc := (&Session{script: DefaultScript, recorder: r}).StopRecording()
+	if len(sc.Submit) != 0 {
+		t.Errorf("没有点击“提交”时submit阶段为%+v", sc.Submit)
+	}
+	if err := sc.Check(); err == nil {
+		t.Error("没有点击“提交”的录制应当检查不通过")
+	}
+}
+
+// requireChrome在找不到Chrome时跳过测试，名字与chromedp查找的一致。
+func requireChrome(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("找不到Chrome")
+}
+
+// radioPage是一组没有id的单选框，默认选中第一个。
+const radioPage = `<!DOCTYPE html>
+<html><body>
+<label><input type="radio" name="fieldXB" value="1" checked>男</label>
+<label><input type="radio" name="fieldXB" value="2">女</label>
+<label><input type="radio" name="fieldXB" value="3">其他</label>
+<a id="submit" href="javascript:void(0)">提交</a>
+</body></html>`
+
+// TestRecordReplayRadio录下选择一组单选框中不是第一个的选项，回放后应当选中同一个选项。
+func TestRecordReplayRadio(t *testing.T) {
+	requireChrome(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+		rw.Write([]byte(radioPage))
+	}))
+	defer srv.Close()
+	fp, err := fingerprint.Get(fingerprint.Names()[0], "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(Config{LoginUrl: srv.URL, SubmitSelector: "#submit"}, 30*time.Second, fp, nil, false)
+	defer s.Close()
+	s.script = &Script{Load: []Step{
+		{Action: ActionNavigate, Url: "${loginUrl}"},
+		{Action: ActionWaitVisible, Selector: "${submitSelector}"},
+	}}
+	s.StartRecording()
+	if err := chromedp.Run(s.clientCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := chromedp.Run(s.timeoutCtx, chromedp.ActionFunc(s.recorder.install)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.runPhase("加载", s.script.Load, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := chromedp.Run(s.timeoutCtx, chromedp.Click(`input[value="3"]`, chromedp.ByQuery)); err != nil {
+		t.Fatal(err)
+	}
+	// 页面报告的操作由事件协程异步处理。
+	for i := 0; i < 50 && len(s.StopRecording().Load) == len(s.script.Load); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	recorded := s.StopRecording().Load
+	if len(recorded) == len(s.script.Load) {
+		t.Fatal("没有录下选择单选框的操作")
+	}
+
+	// 重新打开页面，回放录下的流程，选中的应当是录制时选的选项。
+	if err := s.runPhase("回放", recorded, 0); err != nil {
+		t.Fatal(err)
+	}
+	checked := ""
+	if err := chromedp.Run(s.timeoutCtx, chromedp.Evaluate(`document.querySelector('input[name="fieldXB"]:checked').value`, &checked)); err != nil {
+		t.Fatal(err)
+	}
+	if checked != "3" {
+		t.Errorf("回放%+v后选中的是%q，应为\"3\"", recorded, checked)
+	}
+
+	// 旧的流程把单选框录成了fill，回放时也要选中值相同的那个。
+	if err := s.runPhase("回放fill", []Step{
+		{Action: ActionNavigate, Url: "${loginUrl}"},
+		{Action: ActionWaitVisible, Selector: "${submitSelector}"},
+		{Action: ActionFill, Selector: `input[name="fieldXB"]`, Value: "2"},
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := chromedp.Run(s.timeoutCtx, chromedp.Evaluate(`document.querySelector('input[name="fieldXB"]:checked').value`, &checked)); err != nil {
+		t.Fatal(err)
+	}
+	if checked != "2" {
+		t.Errorf("fill单选框后选中的是%q，应为\"2\"", checked)
+	}
+}
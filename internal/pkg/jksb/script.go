@@ -0,0 +1,309 @@
+package jksb
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// 步骤的动作。
+const (
+	// ActionNavigate打开URL。
+	ActionNavigate = "navigate"
+	// ActionClick点击Selector。
+	ActionClick = "click"
+	// ActionFill把Selector的值设为Value，并触发input和change事件。
+	ActionFill = "fill"
+	// ActionWaitIdle等待上一个动作之后网络空闲，Posts是预计的POST请求数目，忽略则用站点配置中
+	// 这一阶段的数目。
+	ActionWaitIdle = "waitIdle"
+	// ActionWaitVisible等待页面上出现可见的Selector。
+	ActionWaitVisible = "waitVisible"
+	// ActionWaitResponse等待上一个动作之后某个URL中含有Url的请求结束。
+	ActionWaitResponse = "waitResponse"
+	// ActionAssertText检查Selector（忽略则为整个页面）的文字中含有Text，没有则失败。
+	ActionAssertText = "assertText"
+)
+
+// Step是流程中的一步。字符串中的${loginUrl}、${submitSelector}和${formSelector}会替换为站点配置中的值。
+type Step struct {
+	Action   string `json:"action"`
+	Url      string `json:"url,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Posts    *int   `json:"posts,omitempty"`
+}
+
+// Script是用步骤描述的申报流程，分为三个阶段：Load打开申报表，Next进入填写申报表的一步，
+// Submit提交。阶段之间由Session判断结果、改写字段，因此学校改了页面时，通常只需要改流程文件，
+// 不需要重新编译。
+type Script struct {
+	Load   []Step `json:"load"`
+	Next   []Step `json:"next"`
+	Submit []Step `json:"submit"`
+}
+
+//go:embed default_script.json
+var defaultScriptData []byte
+
+// DefaultScript是内嵌的默认流程，与真实的中大系统一致。
+var DefaultScript *Script
+
+func init() {
+	sc, err := parseScript(defaultScriptData)
+	if err != nil {
+		panic(fmt.Sprintf("内嵌的默认流程有误：%s", err.Error()))
+	}
+	DefaultScript = sc
+}
+
+// LoadScript从JSON文件加载流程，格式与内嵌的默认流程相同。
+func LoadScript(filename string) (*Script, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := parseScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("流程文件%s有误：%s", filename, err.Error())
+	}
+	return sc, nil
+}
+
+// parseScript解析并检查流程。
+func parseScript(data []byte) (*Script, error) {
+	sc := &Script{}
+	if err := json.Unmarshal(data, sc); err != nil {
+		return nil, err
+	}
+	if err := sc.Check(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Save把流程写入JSON文件。
+func (sc *Script) Save(filename string) error {
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Check检查流程是否完整：每个阶段都不能为空，每一步都要有动作所需的参数。
+func (sc *Script) Check() error {
+	phases := []struct {
+		name  string
+		steps []Step
+	}{{"load", sc.Load}, {"next", sc.Next}, {"submit", sc.Submit}}
+	for _, p := range phases {
+		if len(p.steps) == 0 {
+			return fmt.Errorf("流程的%s阶段为空", p.name)
+		}
+		for i, st := range p.steps {
+			if err := st.check(); err != nil {
+				return fmt.Errorf("流程%s阶段的第%d步：%s", p.name, i+1, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// check检查一步是否有动作所需的参数。
+func (st *Step) check() error {
+	switch st.Action {
+	case ActionNavigate:
+		if st.Url == "" {
+			return fmt.Errorf("%s缺少url", st.Action)
+		}
+	case ActionClick, ActionFill, ActionWaitVisible:
+		if st.Selector == "" {
+			return fmt.Errorf("%s缺少selector", st.Action)
+		}
+	case ActionWaitResponse:
+		if st.Url == "" {
+			return fmt.Errorf("%s缺少url", st.Action)
+		}
+	case ActionAssertText:
+		if st.Text == "" {
+			return fmt.Errorf("%s缺少text", st.Action)
+		}
+	case ActionWaitIdle:
+		if st.Posts != nil && *st.Posts < 0 {
+			return fmt.Errorf("%s的posts不能为负数", st.Action)
+		}
+	default:
+		return fmt.Errorf("未知的动作%q", st.Action)
+	}
+	return nil
+}
+
+// fillScript把一个元素设为给定的值，并触发input和change事件，复选框则点击之。选择器匹配的是
+// 一组单选框（比如input[name="x"]）时，点击其中值为给定值的那个。两个%s依次为选择器和值的JSON，
+// 元素不存在时返回false。
+const fillScript = `((selector, value) => {
+  const els = document.querySelectorAll(selector);
+  const el = els[0];
+  if (!el) {
+    return false;
+  }
+  if (el.type === "radio") {
+    const target = Array.from(els).find((e) => e.type === "radio" && e.value === value);
+    if (!target) {
+      return false;
+    }
+    if (!target.checked) {
+      target.click();
+    }
+    return true;
+  }
+  if (el.type === "checkbox") {
+    if (el.checked !== (el.value === value)) {
+      el.click();
+    }
+    return true;
+  }
+  el.focus();
+  el.value = value;
+  el.dispatchEvent(new Event("input", { bubbles: true }));
+  el.dispatchEvent(new Event("change", { bubbles: true }));
+  el.blur();
+  return true;
+})(%s, %s)`
+
+// textScript返回一个元素的文字，%s为选择器的JSON，为null时返回整个页面的文字。
+const textScript = `((selector) => {
+  const el = selector === null ? document.body : document.querySelector(selector);
+  return el ? el.innerText : "";
+})(%s)`
+
+// driver是执行步骤所需的浏览器操作，Session用chromedp实现，测试中可以换成假的。
+type driver interface {
+	// mark返回网络活动的快照，等待类的步骤等的是快照之后的网络活动。
+	mark() netMark
+	navigate(url string) error
+	click(sel string) error
+	// fill把sel的值设为value，页面上没有sel时返回false。
+	fill(sel, value string) (bool, error)
+	waitIdle(what string, m netMark, minPosts int) error
+	waitVisible(what, sel string) error
+	waitResponse(what string, m netMark, substr string) error
+	// text返回sel的文字，sel为空时返回整个页面的文字。
+	text(sel string) (string, error)
+}
+
+// chromeDriver在Session的浏览器中执行步骤。
+type chromeDriver struct {
+	s *Session
+}
+
+func (d chromeDriver) mark() netMark {
+	return d.s.net.mark()
+}
+
+func (d chromeDriver) navigate(url string) error {
+	return chromedp.Run(d.s.timeoutCtx, chromedp.Navigate(url))
+}
+
+func (d chromeDriver) click(sel string) error {
+	ctx, cancel := d.s.waitContext()
+	defer cancel()
+	return chromedp.Run(ctx, chromedp.Click(sel, chromedp.ByQuery))
+}
+
+func (d chromeDriver) fill(sel, value string) (bool, error) {
+	selJson, _ := json.Marshal(sel)
+	valueJson, _ := json.Marshal(value)
+	found := false
+	err := chromedp.Run(d.s.timeoutCtx, chromedp.Evaluate(fmt.Sprintf(fillScript, selJson, valueJson), &found))
+	return found, err
+}
+
+func (d chromeDriver) waitIdle(what string, m netMark, minPosts int) error {
+	return d.s.waitIdle(what, m, minPosts)
+}
+
+func (d chromeDriver) waitVisible(what, sel string) error {
+	return d.s.waitSelector(what, sel)
+}
+
+func (d chromeDriver) waitResponse(what string, m netMark, substr string) error {
+	return d.s.waitResponse(what, m, substr)
+}
+
+func (d chromeDriver) text(sel string) (string, error) {
+	selJson := []byte("null")
+	if sel != "" {
+		selJson, _ = json.Marshal(sel)
+	}
+	text := ""
+	err := chromedp.Run(d.s.timeoutCtx, chromedp.Evaluate(fmt.Sprintf(textScript, selJson), &text))
+	return text, err
+}
+
+// expander返回替换步骤中${loginUrl}、${submitSelector}和${formSelector}的函数。
+func (s *Session) expander() func(string) string {
+	return strings.NewReplacer(
+		"${loginUrl}", s.config.LoginUrl,
+		"${submitSelector}", s.config.SubmitSelector,
+		"${formSelector}", s.formSelector(),
+	).Replace
+}
+
+// runPhase在浏览器中依次执行一个阶段的步骤，见runSteps。
+func (s *Session) runPhase(what string, steps []Step, minPosts int) error {
+	return runSteps(chromeDriver{s}, s.expander(), what, steps, minPosts)
+}
+
+// runSteps用d依次执行一个阶段的步骤，expand替换步骤中的变量，what是阶段的名字，用于错误信息，
+// minPosts是这一阶段预计的POST请求数目。等待类的步骤等的是上一个动作之后的网络活动。
+func runSteps(d driver, expand func(string) string, what string, steps []Step, minPosts int) error {
+	m := d.mark()
+	for i, st := range steps {
+		desc := fmt.Sprintf("%s第%d步（%s）", what, i+1, st.Action)
+		var err error
+		switch st.Action {
+		case ActionNavigate:
+			m = d.mark()
+			err = d.navigate(expand(st.Url))
+		case ActionClick:
+			m = d.mark()
+			err = d.click(expand(st.Selector))
+		case ActionFill:
+			m = d.mark()
+			var found bool
+			found, err = d.fill(expand(st.Selector), expand(st.Value))
+			if err == nil && !found {
+				err = fmt.Errorf("页面上没有%s", expand(st.Selector))
+			}
+		case ActionWaitIdle:
+			posts := minPosts
+			if st.Posts != nil {
+				posts = *st.Posts
+			}
+			err = d.waitIdle(desc, m, posts)
+		case ActionWaitVisible:
+			err = d.waitVisible(desc, expand(st.Selector))
+		case ActionWaitResponse:
+			err = d.waitResponse(desc, m, expand(st.Url))
+		case ActionAssertText:
+			var text string
+			text, err = d.text(expand(st.Selector))
+			if err == nil && !strings.Contains(text, expand(st.Text)) {
+				err = fmt.Errorf("页面上没有“%s”", expand(st.Text))
+			}
+		default:
+			err = fmt.Errorf("未知的动作%q", st.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("%s失败：%s", desc, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package jksb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExpander(t *testing.T) {
+	s := &Session{config: Config{LoginUrl: "http://localhost/cas/login", SubmitSelector: "#submit"}}
+	expand := s.expander()
+	if got := expand("${loginUrl}?x=1"); got != "http://localhost/cas/login?x=1" {
+		t.Errorf("${loginUrl}替换为%q", got)
+	}
+	if got := expand("${submitSelector} > a"); got != "#submit > a" {
+		t.Errorf("${submitSelector}替换为%q", got)
+	}
+	if got := expand("${formSelector}"); got != defaultFormSelector {
+		t.Errorf("没有配置formSelector时${formSelector}替换为%q，应为%q", got, defaultFormSelector)
+	}
+	if got := expand("${unknown}"); got != "${unknown}" {
+		t.Errorf("未知的变量替换为%q，应保持不变", got)
+	}
+
+	s.config.FormSelector = "#form_content input"
+	if got := s.expander()("${formSelector}"); got != "#form_content input" {
+		t.Errorf("配置了formSelector时${formSelector}替换为%q", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := DefaultScript.Check(); err != nil {
+		t.Errorf("内嵌的默认流程不完整：%s", err.Error())
+	}
+
+	negative := -1
+	click := Step{Action: ActionClick, Selector: "#a"}
+	tests := []struct {
+		name string
+		sc   Script
+	}{
+		{"空的阶段", Script{Load: []Step{click}, Next: []Step{click}}},
+		{"navigate缺少url", Script{Load: []Step{{Action: ActionNavigate}}, Next: []Step{click}, Submit: []Step{click}}},
+		{"click缺少selector", Script{Load: []Step{click}, Next: []Step{{Action: ActionClick}}, Submit: []Step{click}}},
+		{"waitVisible缺少selector", Script{Load: []Step{click}, Next: []Step{click}, Submit: []Step{{Action: ActionWaitVisible}}}},
+		{"waitResponse缺少url", Script{Load: []Step{click}, Next: []Step{click}, Submit: []Step{{Action: ActionWaitResponse}}}},
+		{"assertText缺少text", Script{Load: []Step{click}, Next: []Step{click}, Submit: []Step{{Action: ActionAssertText}}}},
+		{"posts为负数", Script{Load: []Step{click}, Next: []Step{click}, Submit: []Step{{Action: ActionWaitIdle, Posts: &negative}}}},
+		{"未知的动作", Script{Load: []Step{click}, Next: []Step{click}, Submit: []Step{{Action: "hover"}}}},
+	}
+	for _, test := range tests {
+		if err := test.sc.Check(); err == nil {
+			t.Errorf("%s的流程应当检查不通过", test.name)
+		}
+	}
+
+	if _, err := parseScript([]byte(`{"load": [{"action": "navigate"}]}`)); err == nil {
+		t.Error("parseScript应当检查流程")
+	}
+}
+
+// fakeDriver记录runSteps调用的操作，mark依次返回递增的快照。
+type fakeDriver struct {
+	marks    int
+	calls    []string
+	fail     string
+	notFound bool
+	page     string
+}
+
+func (d *fakeDriver) do(call string) error {
+	d.calls = append(d.calls, call)
+	if d.fail != "" && strings.HasPrefix(call, d.fail) {
+		return errors.New("失败")
+	}
+	return nil
+}
+
+func (d *fakeDriver) mark() netMark {
+	d.marks++
+	return netMark{responses: d.marks}
+}
+
+func (d *fakeDriver) navigate(url string) error {
+	return d.do("navigate " + url)
+}
+
+func (d *fakeDriver) click(sel string) error {
+	return d.do("click " + sel)
+}
+
+func (d *fakeDriver) fill(sel, value string) (bool, error) {
+	return !d.notFound, d.do("fill " + sel + "=" + value)
+}
+
+func (d *fakeDriver) waitIdle(what string, m netMark, minPosts int) error {
+	return d.do(fmt.Sprintf("waitIdle %d %d", m.responses, minPosts))
+}
+
+func (d *fakeDriver) waitVisible(what, sel string) error {
+	return d.do("waitVisible " + sel)
+}
+
+func (d *fakeDriver) waitResponse(what string, m netMark, substr string) error {
+	return d.do(fmt.Sprintf("waitResponse %d %s", m.responses, substr))
+}
+
+func (d *fakeDriver) text(sel string) (string, error) {
+	return d.page, d.do("text " + sel)
+}
+
+func TestRunSteps(t *testing.T) {
+	expand := (&Session{config: Config{LoginUrl: "http://localhost/login", SubmitSelector: "#submit"}}).expander()
+	one := 1
+	steps := []Step{
+		{Action: ActionNavigate, Url: "${loginUrl}"},
+		{Action: ActionWaitIdle},
+		{Action: ActionClick, Selector: "${submitSelector}"},
+		{Action: ActionWaitIdle, Posts: &one},
+		{Action: ActionWaitVisible, Selector: "${formSelector}"},
+		{Action: ActionFill, Selector: "#tw", Value: "36.5"},
+		{Action: ActionWaitResponse, Url: "/doAction"},
+		{Action: ActionAssertText, Text: "成功"},
+	}
+	d := &fakeDriver{page: "提交成功"}
+	if err := runSteps(d, expand, "测试", steps, 3); err != nil {
+		t.Fatal(err)
+	}
+	// 等待类的步骤等的是上一个动作之前取的快照，posts忽略时用阶段的数目。
+	want := []string{
+		"navigate http://localhost/login",
+		"waitIdle 2 3",
+		"click #submit",
+		"waitIdle 3 1",
+		"waitVisible " + defaultFormSelector,
+		"fill #tw=36.5",
+		"waitResponse 4 /doAction",
+		"text ",
+	}
+	if strings.Join(d.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("执行的操作为\n%s\n应为\n%s", strings.Join(d.calls, "\n"), strings.Join(want, "\n"))
+	}
+
+	// 出错时停在出错的一步，错误信息指出是哪一步。
+	d = &fakeDriver{fail: "click"}
+	err := runSteps(d, expand, "测试", steps, 3)
+	if err == nil || !strings.Contains(err.Error(), "测试第3步（click）") {
+		t.Errorf("点击失败时返回%v", err)
+	}
+	if len(d.calls) != 3 {
+		t.Errorf("出错之后还执行了%v", d.calls[3:])
+	}
+
+	for _, test := range []struct {
+		d    *fakeDriver
+		step Step
+	}{
+		{&fakeDriver{notFound: true}, Step{Action: ActionFill, Selector: "#tw", Value: "36.5"}},
+		{&fakeDriver{page: "请填写体温"}, Step{Action: ActionAssertText, Text: "成功"}},
+		{&fakeDriver{}, Step{Action: "hover"}},
+	} {
+		if err := runSteps(test.d, expand, "测试", []Step{test.step}, 0); err == nil {
+			t.Errorf("%+v应当失败", test.step)
+		}
+	}
+}
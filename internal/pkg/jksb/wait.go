@@ -0,0 +1,232 @@
+package jksb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// 等待的默认参数，站点配置中没有指定时使用。
+const (
+	defaultQuietPeriod = 500 * time.Millisecond
+	defaultWaitTimeout = 30 * time.Second
+)
+
+// defaultFormSelector匹配infoplus申报表的字段，只在填写申报表的一步才有。
+const defaultFormSelector = `[name^="field"]`
+
+// 网络空闲判断中忽略的资源类型：图片、字体等不影响页面逻辑，长连接则永远不会结束。
+var ignoredResourceTypes = map[network.ResourceType]bool{
+	network.ResourceTypeImage:       true,
+	network.ResourceTypeMedia:       true,
+	network.ResourceTypeFont:        true,
+	network.ResourceTypeTextTrack:   true,
+	network.ResourceTypeEventSource: true,
+	network.ResourceTypeWebSocket:   true,
+	network.ResourceTypeManifest:    true,
+	network.ResourceTypePing:        true,
+}
+
+// netWatcher跟踪页面的网络活动，用来等待网络空闲或者某个响应。事件来自chromedp的事件协程，
+// 等待发生在调用方的协程，因此所有状态都由mutex保护，状态变化时关闭changed来唤醒等待的一方。
+type netWatcher struct {
+	mutex      sync.Mutex
+	inflight   map[network.RequestID]bool
+	urls       map[network.RequestID]string
+	postsDone  int
+	responses  []string
+	lastActive time.Time
+	changed    chan struct{}
+}
+
+// netMark是某一时刻网络活动的快照，等待时只看这之后发生的事。
+type netMark struct {
+	time      time.Time
+	postsDone int
+	responses int
+}
+
+func newNetWatcher() *netWatcher {
+	return &netWatcher{
+		inflight: map[network.RequestID]bool{},
+		urls:     map[network.RequestID]string{},
+		changed:  make(chan struct{}),
+	}
+}
+
+// handle处理一个网络事件，不是网络事件的忽略。
+func (w *netWatcher) handle(v interface{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	switch ev := v.(type) {
+	case *network.EventRequestWillBeSent:
+		if ignoredResourceTypes[ev.Type] {
+			return
+		}
+		w.inflight[ev.RequestID] = ev.Request.Method == "POST"
+		w.urls[ev.RequestID] = ev.Request.URL
+	case *network.EventLoadingFinished:
+		if !w.finish(ev.RequestID) {
+			return
+		}
+	case *network.EventLoadingFailed:
+		if !w.finish(ev.RequestID) {
+			return
+		}
+	default:
+		return
+	}
+	w.lastActive = time.Now()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// finish记录一个请求结束，不是正在跟踪的请求则返回false。调用时需要持有锁。
+func (w *netWatcher) finish(id network.RequestID) bool {
+	post, ok := w.inflight[id]
+	if !ok {
+		return false
+	}
+	if post {
+		w.postsDone++
+	}
+	w.responses = append(w.responses, w.urls[id])
+	delete(w.inflight, id)
+	delete(w.urls, id)
+	return true
+}
+
+// mark返回当前的快照，需要在触发网络活动的操作（比如点击）之前调用。
+func (w *netWatcher) mark() netMark {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return netMark{time: time.Now(), postsDone: w.postsDone, responses: len(w.responses)}
+}
+
+// wait反复检查check，直到其返回true、ctx结束为止。check在持有锁时调用，返回false时可以同时给出
+// 多久之后需要再检查一次（0表示等到下一次网络事件）。
+func (w *netWatcher) wait(ctx context.Context, check func(now time.Time) (bool, time.Duration)) error {
+	for {
+		w.mutex.Lock()
+		done, recheck := check(time.Now())
+		changed := w.changed
+		w.mutex.Unlock()
+		if done {
+			return nil
+		}
+
+		var t *time.Timer
+		var timer <-chan time.Time
+		if recheck > 0 {
+			t = time.NewTimer(recheck)
+			timer = t.C
+		}
+		select {
+		case <-changed:
+		case <-timer:
+		case <-ctx.Done():
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// waitIdle等待网络空闲：m之后没有正在进行的请求，并且安静了quiet这么久。m之后结束的POST请求
+// 还不到minPosts个时，要安静4倍的时间才算空闲，这样请求比预期的少时不会一直阻塞，比预期的多时
+// 也不会过早返回。
+func (w *netWatcher) waitIdle(ctx context.Context, m netMark, minPosts int, quiet time.Duration) error {
+	return w.wait(ctx, func(now time.Time) (bool, time.Duration) {
+		if len(w.inflight) > 0 {
+			return false, 0
+		}
+		need := quiet
+		if w.postsDone-m.postsDone < minPosts {
+			need = 4 * quiet
+		}
+		since := m.time
+		if w.lastActive.After(since) {
+			since = w.lastActive
+		}
+		if idle := now.Sub(since); idle < need {
+			return false, need - idle
+		}
+		return true, 0
+	})
+}
+
+// waitResponse等待m之后某个URL中含有substr的请求结束。
+func (w *netWatcher) waitResponse(ctx context.Context, m netMark, substr string) error {
+	return w.wait(ctx, func(time.Time) (bool, time.Duration) {
+		for _, u := range w.responses[m.responses:] {
+			if strings.Contains(u, substr) {
+				return true, 0
+			}
+		}
+		return false, 0
+	})
+}
+
+// quietPeriod返回判断网络空闲所需的安静时间。
+func (s *Session) quietPeriod() time.Duration {
+	if s.config.QuietPeriod > 0 {
+		return time.Duration(s.config.QuietPeriod) * time.Millisecond
+	}
+	return defaultQuietPeriod
+}
+
+// formSelector返回只在填写申报表的一步才有的元素的选择器。
+func (s *Session) formSelector() string {
+	if s.config.FormSelector != "" {
+		return s.config.FormSelector
+	}
+	return defaultFormSelector
+}
+
+// waitContext返回一次等待所用的context，不超过每次等待的最长时间，也不超过整个会话的超时。
+func (s *Session) waitContext() (context.Context, context.CancelFunc) {
+	timeout := defaultWaitTimeout
+	if s.config.WaitTimeout > 0 {
+		timeout = time.Duration(s.config.WaitTimeout) * time.Second
+	}
+	return context.WithTimeout(s.timeoutCtx, timeout)
+}
+
+// waitIdle等待m之后网络空闲，what用于错误信息。
+func (s *Session) waitIdle(what string, m netMark, minPosts int) error {
+	ctx, cancel := s.waitContext()
+	defer cancel()
+	if err := s.net.waitIdle(ctx, m, minPosts, s.quietPeriod()); err != nil {
+		return fmt.Errorf("等待%s后网络空闲超时", what)
+	}
+	return nil
+}
+
+// waitResponse等待m之后某个URL中含有substr的请求结束，what用于错误信息。
+func (s *Session) waitResponse(what string, m netMark, substr string) error {
+	ctx, cancel := s.waitContext()
+	defer cancel()
+	if err := s.net.waitResponse(ctx, m, substr); err != nil {
+		return fmt.Errorf("等待%s的响应%s超时", what, substr)
+	}
+	return nil
+}
+
+// waitSelector等待页面上出现可见的sel元素，what用于错误信息。
+func (s *Session) waitSelector(what, sel string) error {
+	ctx, cancel := s.waitContext()
+	defer cancel()
+	if err := chromedp.Run(ctx, chromedp.WaitVisible(sel, chromedp.ByQuery)); err != nil {
+		return fmt.Errorf("等待%s时页面上没有出现%s", what, sel)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+profile包管理站点配置。每所学校的cas系统和jksb系统（同样是Apereo CAS加infoplus）只是地址、
+cookie域名、按钮选择器和POST请求数目不同，这些都写在站点配置里。内嵌了中大的配置，
+也可以从JSON文件加载更多的配置，每名用户属于其中一个站点。
+*/
+package profile
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"jksbx/internal/pkg/jksb"
+	"jksbx/pkg/cas"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default是默认站点的名字，没有指定站点的用户属于这个站点。
+const Default = "sysu"
+
+//go:embed sysu.json
+var defaultProfiles []byte
+
+// Profile是一个站点的配置。
+type Profile struct {
+	Name string        `json:"name"`
+	Cas  cas.Endpoints `json:"cas"`
+	Jksb jksb.Config   `json:"jksb"`
+}
+
+var profiles map[string]*Profile
+var profilesMutex sync.RWMutex
+
+func init() {
+	list, err := parse(defaultProfiles)
+	if err != nil {
+		panic(err)
+	}
+	profiles = map[string]*Profile{}
+	for _, p := range list {
+		profiles[p.Name] = p
+	}
+}
+
+// FromBaseUrls根据cas系统和jksb系统的基地址生成一个站点配置，路径、按钮和POST请求数目
+// 与内嵌的中大配置一致，casBase形如https://cas.sysu.edu.cn/cas，jksbBase形如http://jksb.sysu.edu.cn。
+func FromBaseUrls(name, casBase, jksbBase string) (*Profile, error) {
+	u, err := url.Parse(casBase)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("cas系统的地址%s不包含域名", casBase)
+	}
+	casBase = strings.TrimSuffix(casBase, "/")
+	jksbBase = strings.TrimSuffix(jksbBase, "/")
+
+	list, err := parse(defaultProfiles)
+	if err != nil {
+		return nil, err
+	}
+	p := list[0]
+	p.Name = name
+	p.Cas = cas.Endpoints{LoginUrl: casBase + "/login", CaptchaUrl: casBase + "/captcha.jsp", ValidateUrl: casBase + "/serviceValidate"}
+	p.Jksb.LoginUrl = casBase + "/login?service=" + jksbBase + "/infoplus/login?retUrl=" + jksbBase + "/infoplus/form/XNYQSB/start"
+	p.Jksb.CookieDomain = u.Hostname()
+	p.Jksb.CookiePath = strings.TrimSuffix(u.Path, "/")
+	p.Jksb.CookieSecure = u.Scheme == "https"
+	return p, nil
+}
+
+// Check检查站点配置是否完整。
+func (p *Profile) Check() error {
+	if p.Name == "" {
+		return fmt.Errorf("站点配置缺少name")
+	}
+	if p.Cas.LoginUrl == "" || p.Cas.CaptchaUrl == "" {
+		return fmt.Errorf("站点%s的cas系统配置缺少loginUrl或captchaUrl", p.Name)
+	}
+	if err := p.Jksb.Check(); err != nil {
+		return fmt.Errorf("站点%s：%s", p.Name, err.Error())
+	}
+	return nil
+}
+
+// parse解析JSON数组形式的站点配置，并逐个检查。
+func parse(data []byte) ([]*Profile, error) {
+	list := []*Profile{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, p := range list {
+		if err := p.Check(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// LoadFile从JSON文件加载站点配置，文件内容为站点配置的数组。与已有站点同名的配置会覆盖已有的。
+func LoadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	list, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s：%s", filename, err.Error())
+	}
+	for _, p := range list {
+		Register(p)
+	}
+	return nil
+}
+
+// Register注册一个站点配置，与已有站点同名时覆盖。
+func Register(p *Profile) {
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	profiles[p.Name] = p
+}
+
+// Get返回给定名字的站点配置，名字为空时返回默认站点。
+func Get(name string) (*Profile, error) {
+	if name == "" {
+		name = Default
+	}
+	profilesMutex.RLock()
+	defer profilesMutex.RUnlock()
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的站点：%s", name)
+	}
+	return p, nil
+}
+
+// Names返回按字典序排好的所有站点的名字。
+func Names() []string {
+	profilesMutex.RLock()
+	defer profilesMutex.RUnlock()
+	ret := make([]string, 0, len(profiles))
+	for name := range profiles {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// JksbService返回jksb系统在cas系统中的service地址，即Jksb.LoginUrl中的service参数。
+func (p *Profile) JksbService() (string, error) {
+	u, err := url.Parse(p.Jksb.LoginUrl)
+	if err != nil {
+		return "", err
+	}
+	service := u.Query().Get("service")
+	if service == "" {
+		return "", fmt.Errorf("站点%s的jksb登录地址中没有service参数", p.Name)
+	}
+	return service, nil
+}
@@ -0,0 +1,49 @@
+/*
+proxy包解析出站代理的设置。同一个代理地址既用于登录cas系统的Go HTTP客户端，也用于提交
+申报表的Chrome（--proxy-server），两者的流量都从同一个出口出去。
+*/
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Direct是一种特殊的代理地址，表示直接连接，用来让个别用户不用默认的代理。
+const Direct = "direct"
+
+// Parse解析代理地址，支持http、https和socks5，形如socks5://127.0.0.1:1080，空串或Direct表示
+// 不用代理，返回nil。Chrome不支持在--proxy-server里带用户名和密码，因此代理地址也不能带。
+func Parse(s string) (*url.URL, error) {
+	if s == "" || s == Direct {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址%s不正确：%s", s, err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("不支持的代理协议%s，只支持http、https和socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("代理地址%s缺少主机和端口", s)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("代理地址%s不能带用户名和密码", s)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// ChromeFlags返回让Chrome经由代理u的启动参数，u为nil时返回空。Chrome默认不代理访问本机的
+// 请求，这里去掉这个例外，本地的假站点也能经由代理访问。
+func ChromeFlags(u *url.URL) map[string]interface{} {
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"proxy-server":      u.String(),
+		"proxy-bypass-list": "<-loopback>",
+	}
+}
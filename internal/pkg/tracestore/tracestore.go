@@ -0,0 +1,188 @@
+/*
+tracestore包保存提交申报表失败时留下的现场，每次失败一个目录，里面有截图、DOM、HAR和错误信息。
+保存时按数目和时间清理旧的现场，以免占满磁盘。
+*/
+package tracestore
+
+import (
+	"fmt"
+	"jksbx/internal/pkg/jksb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 现场目录中的文件名。
+const (
+	ScreenshotFile = "screenshot.png"
+	DOMFile        = "dom.html"
+	HARFile        = "network.har"
+	ErrorFile      = "error.txt"
+)
+
+// Files是现场目录中可能有的所有文件。
+var Files = []string{ScreenshotFile, DOMFile, HARFile, ErrorFile}
+
+// idPattern是现场的编号，形如20221015-073000.123-NetID，重名时后面再加上-2等后缀，只允许这些字符，防止路径穿越。
+var idPattern = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}\.[0-9]{3}-[A-Za-z0-9_.@-]+$`)
+
+// Entry是一个已保存的现场。
+type Entry struct {
+	Id       string    `json:"id"`
+	Username string    `json:"username"`
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error"`
+	Files    []string  `json:"files"`
+}
+
+// Store是保存现场的目录。MaxCount和MaxAge为0时表示不限制。Store可以被多个协程并发使用。
+type Store struct {
+	dir      string
+	maxCount int
+	maxAge   time.Duration
+	mutex    sync.Mutex
+}
+
+// Open打开保存现场的目录，不存在则新建。保存时只保留最新的maxCount个、不超过maxAge的现场。
+func Open(dir string, maxCount int, maxAge time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, maxCount: maxCount, maxAge: maxAge}, nil
+}
+
+// Save保存一名用户的一次失败，cause是失败的原因，t可以为nil（比如浏览器已经崩溃），返回现场的编号。
+func (s *Store) Save(username string, cause error, t *jksb.Trace) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	safeName := strings.Map(func(r rune) rune {
+		if r < 128 && (r == '_' || r == '.' || r == '@' || r == '-' ||
+			('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')) {
+			return r
+		}
+		return '_'
+	}, username)
+	// 同一毫秒内可能有同名（或者都只剩下_的非ASCII用户名）的失败，此时加上-2、-3等后缀。
+	base := now.Format("20060102-150405.000") + "-" + safeName
+	id, dir := "", ""
+	for n := 1; ; n++ {
+		id = base
+		if n > 1 {
+			id += "-" + strconv.Itoa(n)
+		}
+		dir = filepath.Join(s.dir, id)
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	files := map[string][]byte{ErrorFile: []byte(username + "\n" + cause.Error() + "\n")}
+	if t != nil {
+		if len(t.Screenshot) > 0 {
+			files[ScreenshotFile] = t.Screenshot
+		}
+		if t.DOM != "" {
+			files[DOMFile] = []byte(t.DOM)
+		}
+		if len(t.HAR) > 0 {
+			files[HARFile] = t.HAR
+		}
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	s.prune(now)
+	return id, nil
+}
+
+// prune删除超出数目或者过期的现场，调用时需要持有锁。
+func (s *Store) prune(now time.Time) {
+	entries, err := s.list()
+	if err != nil {
+		return
+	}
+	for i, e := range entries {
+		if (s.maxCount > 0 && i >= s.maxCount) || (s.maxAge > 0 && now.Sub(e.Time) > s.maxAge) {
+			os.RemoveAll(filepath.Join(s.dir, e.Id))
+		}
+	}
+}
+
+// List返回所有已保存的现场，最新的在前。
+func (s *Store) List() ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.list()
+}
+
+// list是List的实现，调用时需要持有锁。
+func (s *Store) list() ([]Entry, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Entry{}
+	for _, de := range dirEntries {
+		if !de.IsDir() || !idPattern.MatchString(de.Name()) {
+			continue
+		}
+		id := de.Name()
+		t, err := time.ParseInLocation("20060102-150405.000", id[:19], time.Local)
+		if err != nil {
+			continue
+		}
+		e := Entry{Id: id, Username: id[20:], Time: t, Files: []string{}}
+		for _, name := range Files {
+			if _, err := os.Stat(filepath.Join(s.dir, id, name)); err == nil {
+				e.Files = append(e.Files, name)
+			}
+		}
+		if data, err := os.ReadFile(filepath.Join(s.dir, id, ErrorFile)); err == nil {
+			lines := strings.SplitN(string(data), "\n", 2)
+			if len(lines) == 2 {
+				e.Username = lines[0]
+				e.Error = strings.TrimSpace(lines[1])
+			}
+		}
+		ret = append(ret, e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Id > ret[j].Id
+	})
+	return ret, nil
+}
+
+// Path返回一个现场中某个文件的路径，编号或文件名不合法、文件不存在时返回错误。
+func (s *Store) Path(id, name string) (string, error) {
+	if !idPattern.MatchString(id) {
+		return "", fmt.Errorf("现场编号%s不正确", id)
+	}
+	valid := false
+	for _, f := range Files {
+		if f == name {
+			valid = true
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("现场中没有文件%s", name)
+	}
+	path := filepath.Join(s.dir, id, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("现场%s中没有文件%s", id, name)
+	}
+	return path, nil
+}
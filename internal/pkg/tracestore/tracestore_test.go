@@ -0,0 +1,136 @@
+package tracestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// makeEntry在现场目录中建一个指定时间的现场，只有错误信息。
+func makeEntry(t *testing.T, dir string, at time.Time, username string) string {
+	id := at.Format("20060102-150405.000") + "-" + username
+	if err := os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id, ErrorFile), []byte(username+"\n失败\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2022, 10, 15, 7, 30, 0, 0, time.Local)
+	ages := []time.Duration{0, time.Hour, 2 * time.Hour, 25 * time.Hour, 49 * time.Hour}
+	tests := []struct {
+		name     string
+		maxCount int
+		maxAge   time.Duration
+		kept     int
+	}{
+		{"不限制", 0, 0, 5},
+		{"按数目", 3, 0, 3},
+		{"按时间", 0, 24 * time.Hour, 3},
+		{"数目更严", 2, 24 * time.Hour, 2},
+		{"时间更严", 4, 90 * time.Minute, 2},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		ids := []string{}
+		for _, age := range ages {
+			ids = append(ids, makeEntry(t, dir, now.Add(-age), "alice"))
+		}
+		s, err := Open(dir, tt.maxCount, tt.maxAge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.prune(now)
+		entries, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.Id)
+		}
+		// 留下的应当是最新的kept个。
+		if want := ids[:tt.kept]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s：留下%v，应为%v", tt.name, got, want)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := makeEntry(t, dir, time.Date(2022, 10, 15, 7, 30, 0, 123e6, time.Local), "alice")
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		file string
+		ok   bool
+	}{
+		{"正常", id, ErrorFile, true},
+		{"文件不存在", id, ScreenshotFile, false},
+		{"未知的文件名", id, "secret.txt", false},
+		{"文件名穿越", id, "../secret.txt", false},
+		{"编号穿越", "..", "secret.txt", false},
+		{"编号中带路径", id + "/..", ErrorFile, false},
+		{"编号前面带路径", "../" + id, ErrorFile, false},
+		{"编号格式不对", "20221015-073000-alice", ErrorFile, false},
+		{"编号中有非法字符", "20221015-073000.123-a b", ErrorFile, false},
+		{"空编号", "", ErrorFile, false},
+	}
+	for _, tt := range tests {
+		path, err := s.Path(tt.id, tt.file)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s：%v", tt.name, err)
+			} else if want := filepath.Join(dir, id, ErrorFile); path != want {
+				t.Errorf("%s：路径为%s，应为%s", tt.name, path, want)
+			}
+		} else if err == nil {
+			t.Errorf("%s：Path(%q, %q)应当返回错误，却返回了%s", tt.name, tt.id, tt.file, path)
+		}
+	}
+}
+
+// TestSaveUnique检查同一毫秒内化为同一个编号的用户名不会让保存失败。
+func TestSaveUnique(t *testing.T) {
+	s, err := Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		for _, username := range []string{"张三", "李四", "alice"} {
+			id, err := s.Save(username, errors.New("失败"), nil)
+			if err != nil {
+				t.Fatalf("保存%s失败：%v", username, err)
+			}
+			if seen[id] {
+				t.Fatalf("编号%s重复", id)
+			}
+			seen[id] = true
+			if _, err := s.Path(id, ErrorFile); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(seen) {
+		t.Errorf("保存了%d个现场，列出%d个", len(seen), len(entries))
+	}
+}
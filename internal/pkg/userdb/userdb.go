@@ -1,12 +1,14 @@
 /*
-userdb包实现了一个最简的数据库，存储的是(username, password)的键值对，用Go语言
+userdb包实现了一个最简的数据库，存储的是username到用户记录的键值对，用Go语言
 内建的map来存储。每隔一段时间（需调用方指定具体多久）就自动写盘，以此实现持久化。内存
 中的数据库，采用了全局读写锁的机制。
 */
 package userdb
 
 import (
+	"bytes"
 	"encoding/gob"
+	"fmt"
 	"io"
 	"jksbx/internal/pkg/jlog"
 	"os"
@@ -15,14 +17,36 @@ import (
 	"time"
 )
 
+// User是一名用户的记录。Site是用户所属站点的名字，为空表示默认站点。Fingerprint是用户固定
+// 使用的浏览器指纹的名字，为空表示按服务的设置挑选。Proxy是用户专用的出站代理，为空表示用服务
+// 的默认代理，为direct表示直接连接。Fields是提交前要改写的申报表字段，键为字段名，为空表示
+// 沿用申报表预填的值。
+type User struct {
+	Username    string
+	Password    string
+	Site        string
+	Fingerprint string
+	Proxy       string
+	Fields      map[string]string
+}
+
+// database是写盘的格式。最早的格式直接是username到password的map，加载时会自动迁移。
+type database struct {
+	Version int
+	Users   map[string]User
+}
+
+// dbVersion是当前写盘格式的版本。
+const dbVersion = 1
+
 var dbFilename string
-var userData map[string]string
+var userData map[string]User
 var userMutex *sync.RWMutex
 
 // Initialize载入存储了用户信息的数据，相当于是恢复上次的状态。
 func Initialize(filename string) error {
 	dbFilename = filename
-	userData = map[string]string{}
+	userData = map[string]User{}
 	userMutex = &sync.RWMutex{}
 
 	file, err := os.Open(dbFilename)
@@ -45,13 +69,70 @@ func Initialize(filename string) error {
 	return nil
 }
 
+// Load只读地载入用户数据库，用于只需要查询用户的场合。与Initialize不同，它不会新建文件，
+// 也不会把旧格式迁移后写回去，文件不存在时返回错误。Load之后不应调用Save。
+func Load(filename string) error {
+	dbFilename = filename
+	userData = map[string]User{}
+	userMutex = &sync.RWMutex{}
+
+	file, err := os.Open(dbFilename)
+	if err != nil {
+		return err
+	}
+	return loadUserData(file)
+}
+
 // AddUser原子地新增一名用户，如果username已经存在，则会覆盖。
-func AddUser(username, password string) {
+func AddUser(u User) {
 	userMutex.Lock()
 	defer userMutex.Unlock()
 
-	userData[username] = password
-	jlog.Infof("新增用户%s，目前有%d名", username, len(userData))
+	userData[u.Username] = u
+	jlog.Infof("新增用户%s，目前有%d名", u.Username, len(userData))
+}
+
+// GetUser返回一名用户的记录，第二个返回值表示用户是否存在。
+func GetUser(username string) (User, bool) {
+	userMutex.RLock()
+	defer userMutex.RUnlock()
+
+	u, ok := userData[username]
+	return u, ok
+}
+
+// SetFields原子地修改一名用户要改写的申报表字段，值为空串的字段会被删除，即恢复为沿用预填的值。
+// 返回修改后的全部字段，用户不存在时第二个返回值为false。
+func SetFields(username string, fields map[string]string) (map[string]string, bool) {
+	userMutex.Lock()
+	defer userMutex.Unlock()
+
+	u, ok := userData[username]
+	if !ok {
+		return nil, false
+	}
+	merged := make(map[string]string, len(u.Fields)+len(fields))
+	for k, v := range u.Fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	if len(merged) == 0 {
+		merged = nil
+	}
+	u.Fields = merged
+	userData[username] = u
+
+	ret := make(map[string]string, len(merged))
+	for k, v := range merged {
+		ret[k] = v
+	}
+	return ret, true
 }
 
 // DeleteUser原子地删除一名用户。
@@ -68,11 +149,11 @@ func CheckUser(username, password string) bool {
 	userMutex.RLock()
 	defer userMutex.RUnlock()
 
-	pwd, ok := userData[username]
+	u, ok := userData[username]
 	if !ok {
 		return false
 	}
-	return pwd == password
+	return u.Password == password
 }
 
 // ExistsUser检查是否存在用户。
@@ -85,13 +166,22 @@ func ExistsUser(username string) bool {
 }
 
 // ForEach将handler应用到每一名用户上。
-func ForEach(handler func(username, password string)) {
+func ForEach(handler func(u User)) {
 	userMutex.RLock()
 	defer userMutex.RUnlock()
 
-	for username, password := range userData {
-		handler(username, password)
+	for _, u := range userData {
+		handler(u)
+	}
+}
+
+// Save立即将内存中的用户数据写盘，用于不启动StartAutoJob的场合（比如命令行工具）。
+func Save() error {
+	file, err := os.Create(dbFilename)
+	if err != nil {
+		return err
 	}
+	return dumpUserData(file)
 }
 
 // StartAutoJob起一个协程，来定时写盘，并且侦测<Ctrl-C>来写盘。
@@ -121,7 +211,7 @@ func StartAutoJob(duration time.Duration) {
 		}
 	}()
 
-	sigchan := make(chan os.Signal)
+	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, os.Interrupt)
 	go func() {
 		<-sigchan
@@ -143,23 +233,43 @@ func StartAutoJob(duration time.Duration) {
 	}()
 }
 
-// loadUserData载入用户数据。
+// loadUserData载入用户数据，最早的username到password的map格式会迁移为默认站点的用户。
 func loadUserData(r io.ReadCloser) error {
-	dec := gob.NewDecoder(r)
-	userMutex.Lock()
-	err := dec.Decode(&userData)
-	userMutex.Unlock()
+	data, err := io.ReadAll(r)
+	r.Close()
 	if err != nil {
 		return err
 	}
-	return r.Close()
+
+	db := database{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&db); err != nil {
+		legacy := map[string]string{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err != nil {
+			return err
+		}
+		db.Users = make(map[string]User, len(legacy))
+		for username, password := range legacy {
+			db.Users[username] = User{Username: username, Password: password}
+		}
+		jlog.Infof("已将%s从旧格式迁移，共%d名用户", dbFilename, len(legacy))
+	} else if db.Version > dbVersion {
+		return fmt.Errorf("用户数据库的版本为%d，当前程序只支持到%d，请升级程序", db.Version, dbVersion)
+	}
+	if db.Users == nil {
+		db.Users = map[string]User{}
+	}
+
+	userMutex.Lock()
+	userData = db.Users
+	userMutex.Unlock()
+	return nil
 }
 
 // dumpUserData把用户数据写入指定Writer。
 func dumpUserData(w io.WriteCloser) error {
 	enc := gob.NewEncoder(w)
 	userMutex.RLock()
-	err := enc.Encode(userData)
+	err := enc.Encode(database{Version: dbVersion, Users: userData})
 	userMutex.RUnlock()
 	if err != nil {
 		return err
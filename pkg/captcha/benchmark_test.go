@@ -0,0 +1,62 @@
+package captcha
+
+import (
+	"image"
+	"testing"
+)
+
+func BenchmarkSegment(b *testing.B) {
+	images, _ := synthSamples(b, 1, 50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		segment(images[i%len(images)], defaultThreshold)
+	}
+}
+
+func BenchmarkExtractVector(b *testing.B) {
+	images, _ := synthSamples(b, 1, 50)
+	var chars [][]image.Point
+	for _, img := range images {
+		chars = append(chars, segment(img, defaultThreshold)...)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractVector(chars[i%len(chars)])
+	}
+}
+
+func BenchmarkExtractFeatures(b *testing.B) {
+	images, _ := synthSamples(b, 1, 50)
+	var chars [][]image.Point
+	for _, img := range images {
+		chars = append(chars, segment(img, defaultThreshold)...)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractFeatures(chars[i%len(chars)])
+	}
+}
+
+// BenchmarkClassify测量k近邻分类一个字符的耗时，模板数目约为1000个。
+func BenchmarkClassify(b *testing.B) {
+	c := synthModel(b, 1, 250).classifier()
+	images, _ := synthSamples(b, 2, 50)
+	var chars [][]image.Point
+	for _, img := range images {
+		chars = append(chars, segment(img, c.threshold)...)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.classify(chars[i%len(chars)])
+	}
+}
+
+// BenchmarkRecognize测量识别一整张验证码的耗时，包括分割和分类。
+func BenchmarkRecognize(b *testing.B) {
+	r := NewRecognizer(synthModel(b, 1, 250))
+	images, _ := synthSamples(b, 2, 50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Recognize(images[i%len(images)])
+	}
+}
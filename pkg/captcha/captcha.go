@@ -5,12 +5,16 @@ package captcha
 
 import (
 	"image"
+	"math"
+	"sync"
 )
 
+// Alphabet是验证码中可能出现的所有字符。
+const Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
 var (
-	deltaX                     = [8]int{0, 1, 1, 1, 0, -1, -1, -1}
-	deltaY                     = [8]int{1, 1, 0, -1, -1, -1, 0, 1}
-	meanModel map[rune]feature = map[rune]feature{}
+	deltaX = [8]int{0, 1, 1, 1, 0, -1, -1, -1}
+	deltaY = [8]int{1, 1, 0, -1, -1, -1, 0, 1}
 )
 
 type feature struct {
@@ -20,56 +24,110 @@ type feature struct {
 	Numbers [4]float64
 }
 
-// Initialize用给定的模型数据来初始化模型。
-func Initialize(m Model) {
-	for char, modelElement := range m {
-		s := float64(modelElement.Samples)
-		feat := feature{}
-		sum := modelElement.SumFeature
-		feat.Width = sum.Width / s
-		feat.Height = sum.Height / s
-		feat.N = sum.N / s
-		for i, num := range sum.Numbers {
-			feat.Numbers[i] = num / s
-		}
+// Recognizer是由模型构建的验证码识别器，可以被多个协程并发使用，也可以在使用中
+// 用Reload换成另一个模型。不同的Recognizer互不影响，因此可以同时加载多个模型做对比。
+type Recognizer struct {
+	mutex sync.RWMutex
+	model *Model
+	c     *classifier
+	// neural表示使用模型中的神经网络，而不是统计模型。
+	neural bool
+}
+
+// NewRecognizer用给定的模型构建使用统计模型的识别器。构建之后再修改模型（比如继续
+// 添加训练数据），不会影响识别器，需要调用Reload才会生效。
+func NewRecognizer(m *Model) *Recognizer {
+	return &Recognizer{model: m, c: m.classifier()}
+}
+
+// NewNeuralRecognizer用给定模型中的神经网络构建识别器，模型没有神经网络则返回错误。
+func NewNeuralRecognizer(m *Model) (*Recognizer, error) {
+	c, err := m.networkClassifier()
+	if err != nil {
+		return nil, err
+	}
+	return &Recognizer{model: m, c: c, neural: true}, nil
+}
+
+// build按识别器的类型用模型构建分类器。
+func (r *Recognizer) build(m *Model) (*classifier, error) {
+	if r.neural {
+		return m.networkClassifier()
+	}
+	return m.classifier(), nil
+}
+
+// Reload原子地把识别器换成给定的模型，正在进行的识别仍使用旧模型。使用神经网络的
+// 识别器换成没有神经网络的模型时返回错误，识别器保持不变。
+func (r *Recognizer) Reload(m *Model) error {
+	c, err := r.build(m)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.model = m
+	r.c = c
+	r.mutex.Unlock()
+	return nil
+}
+
+// Learn把一张验证码图片及其正确的标注加入识别器当前使用的模型，并立即生效，返回是否
+// 成功。新样本加入的是模型的副本，传给NewRecognizer或Reload的模型不会被修改。可以与
+// 识别并发调用。
+func (r *Recognizer) Learn(captchaImage image.Image, labels string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-		meanModel[char] = feat
+	m := r.model.clone()
+	if !m.AddTrainingData(captchaImage, labels) {
+		return false
 	}
+	r.model = m
+	// 新样本只加入模板，神经网络需要重新训练才能学到。
+	if c, err := r.build(m); err == nil {
+		r.c = c
+	}
+	return true
+}
+
+// Model返回识别器当前使用的模型。
+func (r *Recognizer) Model() *Model {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.model
+}
+
+// classifier返回识别器当前使用的分类器，分类器构建之后是只读的，可以不加锁使用。
+func (r *Recognizer) classifier() *classifier {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.c
 }
 
 // Recognize将识别给定的图片，返回4位小写字母和数字的组合。失败返回空串。
-func Recognize(captcha image.Image) string {
-	// 把有效字符抠出来。
-	chars := denoiseAndSplit(captcha)
+func (r *Recognizer) Recognize(captcha image.Image) string {
+	res, _ := r.classifier().recognize(captcha)
+	return res
+}
+
+// recognize识别图片，返回识别结果以及每个字符距离之和，失败返回空串。
+func (c *classifier) recognize(captcha image.Image) (string, float64) {
+	chars := segment(captcha, c.threshold)
 	if chars == nil {
-		return ""
+		return "", math.Inf(1)
 	}
+
 	res := make([]rune, 0, 4)
+	total := 0.0
 	for _, char := range chars {
-		// 连通块过小则为噪声。
-		if len(char) <= 15 {
-			continue
-		}
-		// 已经有4位字符了，再来就是有问题。
-		if len(res) == 4 {
-			return ""
-		}
-
-		feat := extractFeatures(char)
-		if feat == nil {
-			return ""
-		}
-		ch := recognizeSingle(feat)
+		ch, dis := c.classify(char)
 		if ch == 0 {
-			return ""
+			return "", math.Inf(1)
 		}
 		res = append(res, ch)
+		total += dis
 	}
-
-	if len(res) != 4 {
-		return ""
-	}
-	return string(res)
+	return string(res), total
 }
 
 // extractFeatures对一个单独的字符提取特征，若失败返回nil。
@@ -77,21 +135,7 @@ func extractFeatures(char []image.Point) *feature {
 	feat := feature{}
 	feat.N = float64(len(char))
 
-	left, right, up, down := 0xFFFF, -1, 0xFFFF, -1
-	for _, p := range char {
-		if left > p.X {
-			left = p.X
-		}
-		if right < p.X {
-			right = p.X
-		}
-		if up > p.Y {
-			up = p.Y
-		}
-		if down < p.Y {
-			down = p.Y
-		}
-	}
+	left, right, up, down := bounds(char)
 	feat.Width = float64(right - left + 1)
 	feat.Height = float64(down - up + 1)
 
@@ -111,24 +155,13 @@ func extractFeatures(char []image.Point) *feature {
 	return &feat
 }
 
-// recognizeSingle对给定的特征进行识别，返回识别结果，若失败则返回0。
-func recognizeSingle(feat *feature) rune {
-	// 分数越低约好
-	score := 1e50
-	ret := rune(0)
-	for char, modelFeat := range meanModel {
-		dis := 0.0
-		for i, num := range modelFeat.Numbers {
-			dis += sqr(num - feat.Numbers[i])
-		}
-
-		if score > dis {
-			score = dis
-			ret = char
-		}
+// meanDistance计算特征与均值模型中一个字符的平均特征之间的距离，越小越好。
+func meanDistance(modelFeat, feat *feature) float64 {
+	dis := 0.0
+	for i, num := range modelFeat.Numbers {
+		dis += sqr(num - feat.Numbers[i])
 	}
-
-	return ret
+	return dis
 }
 
 // sqr计算平方。
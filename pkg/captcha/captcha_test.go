@@ -0,0 +1,78 @@
+package captcha
+
+import (
+	"image"
+	"sync"
+	"testing"
+)
+
+// numTemplates返回模型中所有字符的模板总数。
+func numTemplates(m *Model) int {
+	n := 0
+	for _, elem := range m.Chars {
+		n += len(elem.Templates)
+	}
+	return n
+}
+
+func TestLearnDoesNotModifyModel(t *testing.T) {
+	m := synthModel(t, 1, 20)
+	before := m.Info.TrainingImages
+	templates := numTemplates(m)
+
+	r := NewRecognizer(m)
+	images, labels := synthSamples(t, 2, 10)
+	for i := range images {
+		if !r.Learn(images[i], labels[i]) {
+			t.Fatalf("无法学习%s", labels[i])
+		}
+	}
+	if m.Info.TrainingImages != before || numTemplates(m) != templates {
+		t.Error("Learn修改了传给NewRecognizer的模型")
+	}
+	if got := r.Model().Info.TrainingImages; got != before+len(images) {
+		t.Errorf("识别器的模型有%d张训练图片，应为%d张", got, before+len(images))
+	}
+}
+
+func TestLearnConcurrently(t *testing.T) {
+	r := NewRecognizer(synthModel(t, 1, 20))
+	images, labels := synthSamples(t, 2, 20)
+	var wg sync.WaitGroup
+	for i := range images {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			r.Learn(images[i], labels[i])
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			r.Recognize(images[i])
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDatasetSampleConcurrently(t *testing.T) {
+	d, err := OpenDataset(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, _ := synthSamples(t, 1, 10)
+	var wg sync.WaitGroup
+	for _, img := range images {
+		wg.Add(1)
+		go func(img image.Image) {
+			defer wg.Done()
+			idx, err := d.Add(img, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if d.Sample(idx).Filename == "" {
+				t.Error("样本没有文件名")
+			}
+		}(img)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,205 @@
+package captcha
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	// FeatureVersion是模板特征向量的版本，改动extractVector时必须加一，
+	// 旧版本模型中的模板将被忽略，退回到均值模型。
+	FeatureVersion = 1
+
+	zoneCols = 8
+	zoneRows = 10
+	// neighbors是k近邻的k，对每个字符取离它最近的k个模板。
+	neighbors = 3
+	// vectorLen是模板特征向量的维数：分区密度、竖直投影、水平投影、外形特征。
+	vectorLen = zoneCols*zoneRows + zoneCols + zoneRows + 3
+)
+
+// template是一个训练样本字符的特征向量。
+type template struct {
+	char   rune
+	vector []float64
+}
+
+// classifier是由模型构建出来的字符分类器。指定了神经网络时用神经网络分类；模型带有
+// 当前版本的模板时用k近邻分类，最近的k个模板按距离加权投票；否则退回到按田字格像素数
+// 比较的均值模型。
+type classifier struct {
+	threshold Threshold
+	means     map[rune]feature
+	templates []template
+	net       *Network
+}
+
+// classifier根据模型构建分类器。
+func (m *Model) classifier() *classifier {
+	c := &classifier{threshold: m.threshold(), means: m.means()}
+	if m.Version != FeatureVersion {
+		return c
+	}
+	for char, elem := range m.Chars {
+		for _, vec := range elem.Templates {
+			if len(vec) == vectorLen {
+				c.templates = append(c.templates, template{char: char, vector: vec})
+			}
+		}
+	}
+	return c
+}
+
+// networkClassifier根据模型中的神经网络构建分类器，模型没有神经网络则返回错误。
+func (m *Model) networkClassifier() (*classifier, error) {
+	if m.Network == nil {
+		return nil, fmt.Errorf("模型中没有神经网络，请先用train fit -mlp训练")
+	}
+	if m.Version != FeatureVersion {
+		return nil, fmt.Errorf("模型的特征版本为%d，不能使用神经网络", m.Version)
+	}
+	return &classifier{threshold: m.threshold(), net: m.Network}, nil
+}
+
+// classify识别一个单独的字符，返回识别结果及其距离，若失败则返回0。
+func (c *classifier) classify(char []image.Point) (rune, float64) {
+	candidates := c.rank(char)
+	if len(candidates) == 0 {
+		return 0, math.Inf(1)
+	}
+	return candidates[0].Char, candidates[0].Distance
+}
+
+// rank对一个单独的字符给模型中的每个字符打分，按概率从大到小返回所有候选字符，概率相同时
+// 距离小的在前。
+func (c *classifier) rank(char []image.Point) []Candidate {
+	var candidates []Candidate
+	if c.net != nil {
+		candidates = c.net.rank(extractVector(char))
+	} else if len(c.templates) == 0 {
+		feat := extractFeatures(char)
+		if feat == nil {
+			return nil
+		}
+		candidates = make([]Candidate, 0, len(c.means))
+		for ch, modelFeat := range c.means {
+			candidates = append(candidates, Candidate{Char: ch, Distance: meanDistance(&modelFeat, feat)})
+		}
+		normalize(candidates)
+	} else {
+		candidates = c.nearest(extractVector(char))
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Probability != candidates[j].Probability {
+			return candidates[i].Probability > candidates[j].Probability
+		}
+		if candidates[i].Distance != candidates[j].Distance {
+			return candidates[i].Distance < candidates[j].Distance
+		}
+		return candidates[i].Char < candidates[j].Char
+	})
+	return candidates
+}
+
+// nearest在所有模板中找k个最近邻，按距离加权投票，每个字符的概率为它的得票占总票数的比例，
+// 距离为该字符最近的模板距离。没有得票的字符概率为0，只按距离排在后面。
+func (c *classifier) nearest(vec []float64) []Candidate {
+	type neighbor struct {
+		char rune
+		dis  float64
+	}
+	best := make([]neighbor, 0, neighbors+1)
+	closest := map[rune]float64{}
+	for _, t := range c.templates {
+		dis := 0.0
+		for i, v := range t.vector {
+			dis += sqr(v - vec[i])
+		}
+		if d, ok := closest[t.char]; !ok || dis < d {
+			closest[t.char] = dis
+		}
+		if len(best) == neighbors && dis >= best[neighbors-1].dis {
+			continue
+		}
+		best = append(best, neighbor{t.char, dis})
+		sort.Slice(best, func(i, j int) bool { return best[i].dis < best[j].dis })
+		if len(best) > neighbors {
+			best = best[:neighbors]
+		}
+	}
+
+	votes := map[rune]float64{}
+	total := 0.0
+	for _, n := range best {
+		votes[n.char] += 1 / (n.dis + 1e-6)
+		total += 1 / (n.dis + 1e-6)
+	}
+	ret := make([]Candidate, 0, len(closest))
+	for char, dis := range closest {
+		ret = append(ret, Candidate{Char: char, Distance: dis, Probability: votes[char] / total})
+	}
+	return ret
+}
+
+// extractVector对一个单独的字符提取模板特征向量。字符的外接矩形被归一化到
+// zoneCols*zoneRows的网格上，取每格的像素密度，再加上竖直、水平方向的投影直方图，
+// 以及宽高比、填充率和高度这几个外形特征。
+func extractVector(char []image.Point) []float64 {
+	vec := make([]float64, vectorLen)
+	left, right, up, down := bounds(char)
+	width := right - left + 1
+	height := down - up + 1
+
+	zones := vec[:zoneCols*zoneRows]
+	colProj := vec[zoneCols*zoneRows : zoneCols*zoneRows+zoneCols]
+	rowProj := vec[zoneCols*zoneRows+zoneCols : zoneCols*zoneRows+zoneCols+zoneRows]
+	for _, p := range char {
+		zx := (p.X - left) * zoneCols / width
+		zy := (p.Y - up) * zoneRows / height
+		zones[zy*zoneCols+zx]++
+		colProj[zx]++
+		rowProj[zy]++
+	}
+
+	// 各部分都归一化到[0, 1]左右，避免某一部分主导距离。
+	zoneArea := float64(width*height) / float64(zoneCols*zoneRows)
+	for i := range zones {
+		zones[i] /= zoneArea
+	}
+	for i := range colProj {
+		colProj[i] /= float64(len(char))
+	}
+	for i := range rowProj {
+		rowProj[i] /= float64(len(char))
+	}
+
+	shape := vec[zoneCols*zoneRows+zoneCols+zoneRows:]
+	shape[0] = float64(width) / float64(height)
+	shape[1] = float64(len(char)) / float64(width*height)
+	shape[2] = float64(height) / 20
+
+	return vec
+}
+
+// bounds计算一组坐标的外接矩形。
+func bounds(char []image.Point) (left, right, up, down int) {
+	left, right, up, down = 0xFFFF, -1, 0xFFFF, -1
+	for _, p := range char {
+		if left > p.X {
+			left = p.X
+		}
+		if right < p.X {
+			right = p.X
+		}
+		if up > p.Y {
+			up = p.Y
+		}
+		if down < p.Y {
+			down = p.Y
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package captcha
+
+import (
+	"math"
+	"testing"
+)
+
+// vectorAt返回第一维为x、其余维为0的模板特征向量。
+func vectorAt(x float64) []float64 {
+	vec := make([]float64, vectorLen)
+	vec[0] = x
+	return vec
+}
+
+func TestNearestVotes(t *testing.T) {
+	// 'a'的模板多，'b'只有一个更近的模板：两个近邻投给'a'，但'b'离得近，得票更多。
+	c := &classifier{templates: []template{
+		{'a', vectorAt(2)},
+		{'a', vectorAt(3)},
+		{'a', vectorAt(10)},
+		{'b', vectorAt(0.5)},
+		{'c', vectorAt(20)},
+	}}
+	candidates := c.nearest(vectorAt(0))
+	got := map[rune]Candidate{}
+	for _, cand := range candidates {
+		got[cand.Char] = cand
+	}
+	if len(got) != 3 {
+		t.Fatalf("候选字符为%v，应为a、b、c", candidates)
+	}
+
+	// 距离为平方距离，票数为距离的倒数。
+	wa, wb := 1/4.0+1/9.0, 1/0.25
+	if p := got['b'].Probability; math.Abs(p-wb/(wa+wb)) > 1e-6 {
+		t.Errorf("b的概率为%f，应为%f", p, wb/(wa+wb))
+	}
+	if p := got['a'].Probability; math.Abs(p-wa/(wa+wb)) > 1e-6 {
+		t.Errorf("a的概率为%f，应为%f", p, wa/(wa+wb))
+	}
+	if got['c'].Probability != 0 {
+		t.Errorf("c不在3个近邻中，概率应为0，实际为%f", got['c'].Probability)
+	}
+	if d := got['a'].Distance; d != 4 {
+		t.Errorf("a的距离为%f，应为最近模板的距离4", d)
+	}
+}
+
+func TestNearestMajority(t *testing.T) {
+	// 3个近邻中有两个属于'a'，并且不比'b'远多少，'a'应当胜出，即使'b'有更多的模板。
+	c := &classifier{templates: []template{
+		{'a', vectorAt(1.1)},
+		{'a', vectorAt(1.2)},
+		{'b', vectorAt(1)},
+		{'b', vectorAt(5)},
+		{'b', vectorAt(6)},
+		{'b', vectorAt(7)},
+	}}
+	candidates := c.nearest(vectorAt(0))
+	best := candidates[0]
+	for _, cand := range candidates {
+		if cand.Probability > best.Probability {
+			best = cand
+		}
+	}
+	if best.Char != 'a' {
+		t.Errorf("识别为%c，应为a", best.Char)
+	}
+}
@@ -0,0 +1,202 @@
+package captcha
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Candidate是一个字符位置上的候选字符。Distance是它与模型的距离，越小越好；
+// Probability是它在该位置上的概率：k近邻为加权投票中的得票比例，均值模型为把所有候选的
+// 距离归一化后得到的概率，神经网络则直接给出概率。
+type Candidate struct {
+	Char        rune
+	Distance    float64
+	Probability float64
+}
+
+// Result是一张验证码的识别结果，Chars[i]是第i个字符按概率从大到小排列的前k个候选。
+type Result struct {
+	Text  string
+	Chars [][]Candidate
+	// Confidence是每个位置上最优候选的概率之积，可以看作整张验证码识别正确的概率。
+	Confidence float64
+}
+
+// RecognizeWithConfidence识别给定的图片，除了识别结果以外，还给出每个字符的前k个候选
+// 及其概率。分割失败时返回nil。
+func (r *Recognizer) RecognizeWithConfidence(captcha image.Image, k int) *Result {
+	return r.classifier().recognizeWithConfidence(captcha, k)
+}
+
+// recognizeWithConfidence见RecognizeWithConfidence。
+func (c *classifier) recognizeWithConfidence(captcha image.Image, k int) *Result {
+	chars := segment(captcha, c.threshold)
+	if chars == nil {
+		return nil
+	}
+	return c.resultOf(chars, k)
+}
+
+// resultOf识别已经分割好的字符，每个位置保留前k个候选，k不大于0时保留全部。
+func (c *classifier) resultOf(chars [][]image.Point, k int) *Result {
+	res := &Result{Chars: make([][]Candidate, 0, len(chars)), Confidence: 1}
+	text := make([]rune, 0, len(chars))
+	for _, char := range chars {
+		candidates := c.rank(char)
+		if len(candidates) == 0 {
+			return nil
+		}
+		if k > 0 && len(candidates) > k {
+			candidates = candidates[:k]
+		}
+
+		text = append(text, candidates[0].Char)
+		res.Chars = append(res.Chars, candidates)
+		res.Confidence *= candidates[0].Probability
+	}
+	res.Text = string(text)
+	return res
+}
+
+// confidenceSharpness决定概率对距离差异的敏感程度，距离为最优候选两倍的候选，
+// 其概率约为最优候选的e^-8。
+const confidenceSharpness = 8
+
+// normalize根据均值模型的距离计算每个候选的概率。距离没有固定的量纲，因此以最优候选的
+// 距离为尺度做softmax：其余候选离得越远（相对最优距离而言），最优候选的概率就越高。
+func normalize(candidates []Candidate) {
+	if len(candidates) == 0 {
+		return
+	}
+	min := candidates[0].Distance
+	for _, cand := range candidates {
+		min = math.Min(min, cand.Distance)
+	}
+	scale := math.Max(min, 1e-6) / confidenceSharpness
+	sum := 0.0
+	for i := range candidates {
+		candidates[i].Probability = math.Exp(-(candidates[i].Distance - min) / scale)
+		sum += candidates[i].Probability
+	}
+	for i := range candidates {
+		candidates[i].Probability /= sum
+	}
+}
+
+// 分类器的种类，用作Model.MinConfidence的键。
+const (
+	// KindKNN是k近邻，模型带有当前版本的模板时使用。
+	KindKNN = "knn"
+	// KindMeans是均值模型，旧版本的模型只能使用它。
+	KindMeans = "means"
+	// KindNeural是神经网络。
+	KindNeural = "neural"
+)
+
+// defaultMinConfidence是模型没有标定过时各分类器使用的最低置信度。三种分类器的置信度含义不同，
+// 同一个阈值的效果相差很远：k近邻和神经网络的置信度与正确率大致相符，均值模型的置信度却总是
+// 挤在1附近，0.5几乎什么都拦不下。取值依据见doc/technique.md。
+var defaultMinConfidence = map[string]float64{
+	KindKNN:    0.5,
+	KindMeans:  0.998,
+	KindNeural: 0.9,
+}
+
+// CalibrationTarget是标定置信度阈值时，要求提交的识别结果中正确的比例。
+const CalibrationTarget = 0.9
+
+// minAcceptedShare是标定时阈值至少要放行的样本比例，避免为了正确率把几乎所有验证码都拦下。
+const minAcceptedShare = 0.25
+
+// kind返回分类器的种类。
+func (c *classifier) kind() string {
+	switch {
+	case c.net != nil:
+		return KindNeural
+	case len(c.templates) > 0:
+		return KindKNN
+	}
+	return KindMeans
+}
+
+// Kind返回识别器所用分类器的种类：KindKNN、KindMeans或KindNeural。
+func (r *Recognizer) Kind() string {
+	return r.classifier().kind()
+}
+
+// MinConfidence返回提交识别结果所需的最低置信度：模型为这种分类器标定过的就用标定的值，
+// 否则用这种分类器的默认值。
+func (r *Recognizer) MinConfidence() float64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	min, _ := r.model.ConfidenceThreshold(r.c.kind())
+	return min
+}
+
+// ConfidenceThreshold返回模型为kind种分类器标定的最低置信度，没有标定过时返回默认值，
+// 此时第二个返回值为false。
+func (m *Model) ConfidenceThreshold(kind string) (float64, bool) {
+	if v, ok := m.MinConfidence[kind]; ok {
+		return v, true
+	}
+	return defaultMinConfidence[kind], false
+}
+
+// Calibrate用kind种分类器在样本上的评测报告为它标定最低置信度（见CalibrateConfidence），
+// 记录进模型，返回标定的值。报告中没有置信度时返回错误。
+func (m *Model) Calibrate(kind string, report *Report) (float64, error) {
+	min, ok := CalibrateConfidence(report)
+	if !ok {
+		return 0, fmt.Errorf("评测报告中没有分割成功的样本，无法标定置信度阈值")
+	}
+	if m.MinConfidence == nil {
+		m.MinConfidence = map[string]float64{}
+	}
+	m.MinConfidence[kind] = min
+	return min, nil
+}
+
+// CalibrateConfidence根据评测报告选择置信度阈值：在至少放行四分之一样本的阈值中，取放行的
+// 样本中正确的比例达到CalibrationTarget的最小阈值；都达不到时取正确比例最高的。候选阈值是报告中
+// 出现过的置信度。报告中没有置信度时第二个返回值为false。
+func CalibrateConfidence(r *Report) (float64, bool) {
+	if len(r.Scores) == 0 {
+		return 0, false
+	}
+	scores := make([]Score, len(r.Scores))
+	copy(scores, r.Scores)
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Confidence > scores[j].Confidence })
+
+	// 从高到低逐个放行，accepted、correct是置信度不低于scores[i]的样本数目及其中正确的数目。
+	minAccepted := int(math.Ceil(minAcceptedShare * float64(len(scores))))
+	best, bestPrecision := scores[len(scores)-1].Confidence, -1.0
+	target := scores[len(scores)-1].Confidence
+	found := false
+	correct := 0
+	for i, s := range scores {
+		if s.Correct {
+			correct++
+		}
+		// 置信度相同的样本要一起放行。
+		if i+1 < len(scores) && scores[i+1].Confidence == s.Confidence {
+			continue
+		}
+		accepted := i + 1
+		precision := float64(correct) / float64(accepted)
+		if accepted < minAccepted {
+			continue
+		}
+		if precision >= CalibrationTarget {
+			target, found = s.Confidence, true
+		}
+		if precision > bestPrecision {
+			best, bestPrecision = s.Confidence, precision
+		}
+	}
+	if found {
+		return target, true
+	}
+	return best, true
+}
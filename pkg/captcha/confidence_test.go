@@ -0,0 +1,126 @@
+package captcha
+
+import (
+	"bytes"
+	"testing"
+)
+
+// scoreSynth用识别器识别seed生成的n张验证码，返回只记录了置信度的评测报告。
+func scoreSynth(t *testing.T, r *Recognizer, seed int64, n int) *Report {
+	images, labels := synthSamples(t, seed, n)
+	rep := &Report{}
+	for i, img := range images {
+		rep.NumImages++
+		res, err := r.Solve(img)
+		if err != nil {
+			continue
+		}
+		rep.Scores = append(rep.Scores, Score{Confidence: res.Confidence, Correct: res.Text == labels[i]})
+	}
+	return rep
+}
+
+func TestCalibrateConfidence(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []Score
+		want   float64
+	}{
+		{"全部正确时放行所有", []Score{{0.9, true}, {0.5, true}, {0.1, true}}, 0.1},
+		{"取达到目标的最小阈值", []Score{
+			{0.99, true}, {0.98, true}, {0.97, true}, {0.96, true}, {0.95, true},
+			{0.94, true}, {0.93, true}, {0.92, true}, {0.91, true}, {0.3, false},
+			{0.2, false}, {0.1, false},
+		}, 0.3},
+		{"置信度相同的一起放行", []Score{
+			{0.9, true}, {0.9, false}, {0.8, true}, {0.8, true}, {0.8, true},
+			{0.8, true}, {0.8, true}, {0.8, true}, {0.8, true}, {0.8, true},
+		}, 0.8},
+		{"达不到目标时取正确率最高的", []Score{
+			{0.9, false}, {0.8, true}, {0.7, true}, {0.6, false}, {0.5, false},
+			{0.4, false}, {0.3, false}, {0.2, false},
+		}, 0.7},
+		{"至少放行四分之一", []Score{
+			{0.9, true}, {0.8, false}, {0.7, false}, {0.6, true}, {0.5, false},
+			{0.4, false}, {0.3, false}, {0.2, false},
+		}, 0.8},
+	}
+	for _, tt := range tests {
+		got, ok := CalibrateConfidence(&Report{NumImages: len(tt.scores), Scores: tt.scores})
+		if !ok || got != tt.want {
+			t.Errorf("%s：标定为%v（%v），应为%v", tt.name, got, ok, tt.want)
+		}
+	}
+	if _, ok := CalibrateConfidence(&Report{NumImages: 3}); ok {
+		t.Error("没有置信度的报告不应标定出阈值")
+	}
+}
+
+// TestDefaultMinConfidence检查没有标定时各分类器的默认阈值在合成数据上的效果：
+// 提交的识别结果中正确的比例要达到CalibrationTarget，同时不能拦下太多验证码。
+func TestDefaultMinConfidence(t *testing.T) {
+	// 均值模型：旧版本的模型没有模板，内嵌的默认模型就是这样。
+	means := NewModel()
+	means.Version = 0
+	images, labels := synthSamples(t, 1, 200)
+	for i := range images {
+		means.AddTrainingData(images[i], labels[i])
+	}
+
+	tests := []struct {
+		name        string
+		recognizer  *Recognizer
+		kind        string
+		minAccepted float64
+	}{
+		{"均值模型", NewRecognizer(means), KindMeans, 0.5},
+		{"k近邻", NewRecognizer(synthModel(t, 1, 40)), KindKNN, 0.9},
+	}
+	for _, tt := range tests {
+		if kind := tt.recognizer.Kind(); kind != tt.kind {
+			t.Fatalf("%s的分类器种类为%s，应为%s", tt.name, kind, tt.kind)
+		}
+		rep := scoreSynth(t, tt.recognizer, 2, 500)
+		min := tt.recognizer.MinConfidence()
+		if min != defaultMinConfidence[tt.kind] {
+			t.Errorf("%s的阈值为%v，应为默认值%v", tt.name, min, defaultMinConfidence[tt.kind])
+		}
+		accepted, correct := rep.Acceptance(min)
+		_, allCorrect := rep.Acceptance(0)
+		precision := float64(correct) / float64(accepted)
+		t.Logf("%s：阈值%v，提交%d/%d，其中正确%d；不设阈值时正确%d", tt.name, min, accepted, rep.NumImages, correct, allCorrect)
+		if precision < CalibrationTarget {
+			t.Errorf("%s：提交后正确率%.3f，低于%v", tt.name, precision, CalibrationTarget)
+		}
+		if share := float64(accepted) / float64(rep.NumImages); share < tt.minAccepted {
+			t.Errorf("%s：只提交了%.3f，应至少为%v", tt.name, share, tt.minAccepted)
+		}
+		// 阈值应当拦下错误的结果，让提交后的正确率高于不设阈值时。
+		if ungated := float64(allCorrect) / float64(rep.NumImages); precision <= ungated {
+			t.Errorf("%s：提交后正确率%.3f，不高于不设阈值时的%.3f", tt.name, precision, ungated)
+		}
+	}
+}
+
+func TestMinConfidenceRoundTrip(t *testing.T) {
+	m := synthModel(t, 1, 40)
+	r := NewRecognizer(m)
+	if min, err := m.Calibrate(r.Kind(), scoreSynth(t, r, 2, 100)); err != nil || min <= 0 {
+		t.Fatalf("标定为%v：%v", min, err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := DumpModel(m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadModel(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := NewRecognizer(loaded).MinConfidence(), r.MinConfidence(); got != want {
+		t.Errorf("加载后阈值为%v，应为%v", got, want)
+	}
+	if _, ok := loaded.ConfidenceThreshold(KindNeural); ok {
+		t.Error("没有标定过的分类器应使用默认阈值")
+	}
+}
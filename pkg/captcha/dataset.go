@@ -0,0 +1,219 @@
+package captcha
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ManifestFilename是数据集目录中标注清单的文件名。清单每行为“图片文件名<TAB>验证码”，
+// 验证码为空表示还未标注，#开头的行为注释。
+const ManifestFilename = "labels.txt"
+
+// Sample是数据集中的一张验证码图片，Label为空串表示还未标注。
+type Sample struct {
+	Filename string
+	Label    string
+}
+
+// Dataset是磁盘上的验证码数据集，由一个目录中的若干图片加上一个标注清单组成。
+// 对数据集的修改会立即写盘，因此中途退出不会丢失已经做好的标注。
+type Dataset struct {
+	Dir     string
+	Samples []Sample
+
+	mutex   sync.Mutex
+	nextIdx int
+}
+
+// OpenDataset打开给定目录下的数据集，目录不存在则新建一个空数据集。
+func OpenDataset(dir string) (*Dataset, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &Dataset{Dir: dir}
+
+	f, err := os.Open(filepath.Join(dir, ManifestFilename))
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		s := Sample{Filename: fields[0]}
+		if len(fields) == 2 {
+			s.Label = strings.TrimSpace(fields[1])
+		}
+		if s.Label != "" && len(s.Label) != 4 {
+			return nil, fmt.Errorf("%s第%d行的验证码不是4位", ManifestFilename, lineNo)
+		}
+		d.Samples = append(d.Samples, s)
+		d.updateNextIdx(s.Filename)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Add把一张验证码图片存入数据集，label可以为空串表示未标注，返回新样本的下标。
+func (d *Dataset) Add(captchaImage image.Image, label string) (int, error) {
+	if label != "" && len(label) != 4 {
+		return 0, fmt.Errorf("验证码必须是4位")
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s := Sample{Filename: fmt.Sprintf("%06d.png", d.nextIdx), Label: label}
+	f, err := os.Create(filepath.Join(d.Dir, s.Filename))
+	if err != nil {
+		return 0, err
+	}
+	if err := png.Encode(f, captchaImage); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	// 清单采用追加的方式写入，不需要重写整个文件。
+	manifest, err := os.OpenFile(filepath.Join(d.Dir, ManifestFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(manifest, "%s\t%s\n", s.Filename, s.Label); err != nil {
+		manifest.Close()
+		return 0, err
+	}
+	if err := manifest.Close(); err != nil {
+		return 0, err
+	}
+
+	d.Samples = append(d.Samples, s)
+	d.nextIdx++
+	return len(d.Samples) - 1, nil
+}
+
+// SetLabel修改第i个样本的标注，并立即重写标注清单。
+func (d *Dataset) SetLabel(i int, label string) error {
+	if label != "" && len(label) != 4 {
+		return fmt.Errorf("验证码必须是4位")
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Samples[i].Label = label
+	return d.save()
+}
+
+// Sample返回第i个样本，可以与Add、SetLabel并发调用。
+func (d *Dataset) Sample(i int) Sample {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.Samples[i]
+}
+
+// Image读取给定样本的图片。
+func (d *Dataset) Image(s Sample) (image.Image, error) {
+	f, err := os.Open(filepath.Join(d.Dir, s.Filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// Labeled返回所有已经标注的样本。
+func (d *Dataset) Labeled() []Sample {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ret := make([]Sample, 0, len(d.Samples))
+	for _, s := range d.Samples {
+		if s.Label != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// save先写入临时文件再替换，避免写到一半时退出把清单写坏。调用方需持有锁。
+func (d *Dataset) save() error {
+	filename := filepath.Join(d.Dir, ManifestFilename)
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, s := range d.Samples {
+		fmt.Fprintf(w, "%s\t%s\n", s.Filename, s.Label)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// updateNextIdx保证新图片的编号不会和已有的文件名冲突。
+func (d *Dataset) updateNextIdx(filename string) {
+	idx, err := strconv.Atoi(strings.TrimSuffix(filename, filepath.Ext(filename)))
+	if err == nil && idx >= d.nextIdx {
+		d.nextIdx = idx + 1
+	}
+}
+
+// Train用数据集中给定的已标注样本训练模型，返回训练失败（分割失败）的样本数目。
+// 若模型还是空的，会先在这些样本上学习前景判定的阈值。
+func (m *Model) Train(d *Dataset, samples []Sample) (int, error) {
+	images := make([]image.Image, 0, len(samples))
+	for _, s := range samples {
+		img, err := d.Image(s)
+		if err != nil {
+			return 0, fmt.Errorf("无法读取%s：%s", s.Filename, err.Error())
+		}
+		images = append(images, img)
+	}
+
+	if len(m.Chars) == 0 && len(images) > 0 {
+		if err := m.adaptThreshold(images); err != nil {
+			return 0, err
+		}
+	}
+
+	numFailed := 0
+	for i, img := range images {
+		if !m.AddTrainingData(img, strings.ToLower(samples[i].Label)) {
+			numFailed++
+		}
+	}
+	return numFailed, nil
+}
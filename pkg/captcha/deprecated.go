@@ -0,0 +1,38 @@
+package captcha
+
+import (
+	"image"
+	"io"
+)
+
+// defaultRecognizer是包级函数使用的识别器，Initialize之前使用空模型，识别总是失败。
+var defaultRecognizer = NewRecognizer(NewModel())
+
+// Initialize用给定的模型数据来初始化包级函数使用的识别器。
+//
+// Deprecated: 请用NewRecognizer构建自己的识别器，它可以与其他模型并存，也可以用Reload
+// 热更新。
+func Initialize(m *Model) {
+	defaultRecognizer.Reload(m)
+}
+
+// Recognize用Initialize给定的模型识别图片，返回4位小写字母和数字的组合。失败返回空串。
+//
+// Deprecated: 请使用Recognizer.Recognize。
+func Recognize(captcha image.Image) string {
+	return defaultRecognizer.Recognize(captcha)
+}
+
+// RecognizeWithConfidence用Initialize给定的模型识别图片，并给出每个字符的前k个候选。
+//
+// Deprecated: 请使用Recognizer.RecognizeWithConfidence。
+func RecognizeWithConfidence(captcha image.Image, k int) *Result {
+	return defaultRecognizer.RecognizeWithConfidence(captcha, k)
+}
+
+// DebugDenoise用Initialize给定的模型的阈值提取验证码中字符的部分，见Recognizer.DebugDenoise。
+//
+// Deprecated: 请使用Recognizer.DebugDenoise或Model.DebugDenoise。
+func DebugDenoise(captcha image.Image, outData io.Writer) error {
+	return defaultRecognizer.DebugDenoise(captcha, outData)
+}
@@ -0,0 +1,19 @@
+package captcha
+
+import "testing"
+
+func TestDeprecatedWrappers(t *testing.T) {
+	images, labels := synthSamples(t, 2, 1)
+	if got := Recognize(images[0]); got != "" {
+		t.Errorf("Initialize之前识别为%q，应当失败", got)
+	}
+
+	Initialize(synthModel(t, 1, 100))
+	defer Initialize(NewModel())
+	if got := Recognize(images[0]); got != labels[0] {
+		t.Errorf("识别为%q，应为%q", got, labels[0])
+	}
+	if res := RecognizeWithConfidence(images[0], 1); res == nil || res.Text != labels[0] {
+		t.Errorf("RecognizeWithConfidence的结果为%+v，应为%q", res, labels[0])
+	}
+}
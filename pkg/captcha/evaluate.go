@@ -0,0 +1,228 @@
+package captcha
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// MaxWorstExamples是评测报告中最多保留的最差样本数目。
+const MaxWorstExamples = 10
+
+// Report是模型在一批已标注样本上的评测结果。
+type Report struct {
+	NumImages int
+	// NumSegmented是不靠等宽切分就分出了4个字符的样本数目，NumFallback是退回到等宽切分的
+	// 样本数目，两者之外的样本分割失败。
+	NumSegmented    int
+	NumFallback     int
+	NumCorrect      int
+	NumChars        int
+	NumCharsCorrect int
+	// Confusion[i][j]表示真实字符为Alphabet[i]、识别为Alphabet[j]的次数，统计分出了字符的
+	// 所有样本，包括等宽切分的。
+	Confusion [len(Alphabet)][len(Alphabet)]int
+	// Worst是识别错误的样本，按距离从大到小排列，分割失败的排在最前。
+	Worst []Example
+	// Scores是每张分割成功的样本的置信度，用于选择置信度阈值。
+	Scores []Score
+}
+
+// Score是一张样本识别结果的置信度以及识别是否正确。
+type Score struct {
+	Confidence float64
+	Correct    bool
+}
+
+// Example是一张被识别错误的样本。分割失败时Predicted为空串，Distance为正无穷。
+type Example struct {
+	Filename  string
+	Label     string
+	Predicted string
+	Distance  float64
+}
+
+// SegmentationRate返回分割成功（不靠等宽切分就分出4个字符）的比例。
+func (r *Report) SegmentationRate() float64 {
+	return ratio(r.NumSegmented, r.NumImages)
+}
+
+// FallbackRate返回退回到等宽切分的比例。
+func (r *Report) FallbackRate() float64 {
+	return ratio(r.NumFallback, r.NumImages)
+}
+
+// CharAccuracy返回分出了字符的样本（包括等宽切分的）中，单个字符识别正确的比例。
+func (r *Report) CharAccuracy() float64 {
+	return ratio(r.NumCharsCorrect, r.NumChars)
+}
+
+// Accuracy返回整张验证码识别正确的比例。
+func (r *Report) Accuracy() float64 {
+	return ratio(r.NumCorrect, r.NumImages)
+}
+
+// Acceptance统计置信度不低于min的样本：accepted是这样的样本数目，correct是其中识别正确的
+// 数目。登录时只提交置信度不低于阈值的识别结果，因此accepted/NumImages是一张验证码被提交的
+// 比例，correct/accepted是提交之后验证码正确的比例。
+func (r *Report) Acceptance(min float64) (accepted, correct int) {
+	for _, s := range r.Scores {
+		if s.Confidence >= min {
+			accepted++
+			if s.Correct {
+				correct++
+			}
+		}
+	}
+	return accepted, correct
+}
+
+// Merge把另一份报告累加到这份报告中，用于汇总交叉验证每一折的结果。
+func (r *Report) Merge(o *Report) {
+	r.NumImages += o.NumImages
+	r.NumSegmented += o.NumSegmented
+	r.NumFallback += o.NumFallback
+	r.NumCorrect += o.NumCorrect
+	r.NumChars += o.NumChars
+	r.NumCharsCorrect += o.NumCharsCorrect
+	for i := range r.Confusion {
+		for j := range r.Confusion[i] {
+			r.Confusion[i][j] += o.Confusion[i][j]
+		}
+	}
+	for _, e := range o.Worst {
+		r.addWorst(e)
+	}
+	r.Scores = append(r.Scores, o.Scores...)
+}
+
+// TopConfusions返回最常见的n种识别错误，格式为“真实字符->识别结果”及其次数。
+func (r *Report) TopConfusions(n int) []string {
+	type pair struct {
+		truth, predicted byte
+		count            int
+	}
+	pairs := []pair{}
+	for i := range r.Confusion {
+		for j, count := range r.Confusion[i] {
+			if i != j && count > 0 {
+				pairs = append(pairs, pair{Alphabet[i], Alphabet[j], count})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].count > pairs[j].count })
+
+	ret := []string{}
+	for i := 0; i < len(pairs) && i < n; i++ {
+		ret = append(ret, fmt.Sprintf("%c->%c %d", pairs[i].truth, pairs[i].predicted, pairs[i].count))
+	}
+	return ret
+}
+
+// addWorst把一个错误样本加入最差样本列表中，只保留最差的MaxWorstExamples个。
+func (r *Report) addWorst(e Example) {
+	r.Worst = append(r.Worst, e)
+	sort.SliceStable(r.Worst, func(i, j int) bool { return r.Worst[i].Distance > r.Worst[j].Distance })
+	if len(r.Worst) > MaxWorstExamples {
+		r.Worst = r.Worst[:MaxWorstExamples]
+	}
+}
+
+// Evaluate用数据集中给定的已标注样本评测识别器。
+func Evaluate(recognizer *Recognizer, d *Dataset, samples []Sample) (*Report, error) {
+	c := recognizer.classifier()
+	r := &Report{}
+	for _, s := range samples {
+		img, err := d.Image(s)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取%s：%s", s.Filename, err.Error())
+		}
+		label := strings.ToLower(s.Label)
+		r.NumImages++
+
+		chars, exact := segmentExact(img, c.threshold)
+		if chars == nil {
+			r.addWorst(Example{Filename: s.Filename, Label: label, Distance: math.Inf(1)})
+			continue
+		}
+		if exact {
+			r.NumSegmented++
+		} else {
+			r.NumFallback++
+		}
+
+		res := c.resultOf(chars, 1)
+		if res == nil {
+			r.addWorst(Example{Filename: s.Filename, Label: label, Distance: math.Inf(1)})
+			continue
+		}
+		total := 0.0
+		for i, cands := range res.Chars {
+			ch := cands[0].Char
+			total += cands[0].Distance
+
+			// 数据集保证了标注都是4位。
+			r.NumChars++
+			if rune(label[i]) == ch {
+				r.NumCharsCorrect++
+			}
+			ti := strings.IndexByte(Alphabet, label[i])
+			pi := strings.IndexRune(Alphabet, ch)
+			if ti != -1 && pi != -1 {
+				r.Confusion[ti][pi]++
+			}
+		}
+
+		r.Scores = append(r.Scores, Score{Confidence: res.Confidence, Correct: res.Text == label})
+		if res.Text == label {
+			r.NumCorrect++
+		} else {
+			r.addWorst(Example{Filename: s.Filename, Label: label, Predicted: res.Text, Distance: total})
+		}
+	}
+	return r, nil
+}
+
+// CrossValidate对样本做k折交叉验证：用seed打乱样本后均分成k份，每次用其中k-1份训练
+// 一个新模型，在剩下的一份上评测。返回每一折的报告。
+func CrossValidate(d *Dataset, samples []Sample, k int, seed int64) ([]*Report, error) {
+	if k < 2 || k > len(samples) {
+		return nil, fmt.Errorf("折数必须在2到样本数%d之间", len(samples))
+	}
+
+	shuffled := make([]Sample, len(samples))
+	copy(shuffled, samples)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	reports := make([]*Report, 0, k)
+	for fold := 0; fold < k; fold++ {
+		begin := fold * len(shuffled) / k
+		end := (fold + 1) * len(shuffled) / k
+		trainSet := make([]Sample, 0, len(shuffled)-(end-begin))
+		trainSet = append(trainSet, shuffled[:begin]...)
+		trainSet = append(trainSet, shuffled[end:]...)
+
+		m := NewModel()
+		if _, err := m.Train(d, trainSet); err != nil {
+			return nil, err
+		}
+		r, err := Evaluate(NewRecognizer(m), d, shuffled[begin:end])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// ratio计算a/b，b为0时返回0。
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
@@ -0,0 +1,212 @@
+package captcha
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatVersion是模型文件容器格式的版本，改动header或body的结构时必须加一。
+	FormatVersion = 2
+	// FeatureType描述当前版本模板特征向量的提取方式。
+	FeatureType = "zoning8x10+projection+shape"
+)
+
+// modelMagic是模型文件开头的魔数，没有魔数的文件是旧格式。
+var modelMagic = []byte("JKSBXMDL")
+
+// ModelInfo是模型文件中记录的元数据。
+type ModelInfo struct {
+	// FormatVersion是加载时文件的容器格式版本，0表示没有头部的旧格式。写盘时总是当前版本。
+	FormatVersion int
+	CreatedAt     time.Time
+	// TrainingImages是参与训练（分割成功）的验证码图片数目。
+	TrainingImages int
+	// Metrics是训练时在训练集上做交叉验证得到的评测结果，没有评测过则为nil。
+	Metrics *Metrics
+}
+
+// Metrics是记录进模型文件的评测结果。
+type Metrics struct {
+	Folds            int
+	NumImages        int
+	SegmentationRate float64
+	FallbackRate     float64
+	CharAccuracy     float64
+	Accuracy         float64
+}
+
+// NewMetrics把一份评测报告转换为可以记录进模型文件的评测结果，folds为交叉验证的折数。
+func NewMetrics(r *Report, folds int) *Metrics {
+	return &Metrics{
+		Folds:            folds,
+		NumImages:        r.NumImages,
+		SegmentationRate: r.SegmentationRate(),
+		FallbackRate:     r.FallbackRate(),
+		CharAccuracy:     r.CharAccuracy(),
+		Accuracy:         r.Accuracy(),
+	}
+}
+
+// header是模型文件的头部，紧跟在魔数之后，之后是body。
+type header struct {
+	FormatVersion  int
+	FeatureType    string
+	FeatureVersion int
+	Alphabet       string
+	Threshold      *Threshold
+	MinConfidence  map[string]float64
+	SampleCounts   map[rune]int
+	CreatedAt      time.Time
+	TrainingImages int
+	Metrics        *Metrics
+}
+
+// body是模型文件的主体。
+type body struct {
+	Chars   map[rune]*modelElement
+	Network *Network
+}
+
+// DumpModel将一个内存中的模型写入给定Writer中，出错则返回错误。
+func DumpModel(m *Model, w io.Writer) error {
+	h := header{
+		FormatVersion:  FormatVersion,
+		FeatureType:    FeatureType,
+		FeatureVersion: m.Version,
+		Alphabet:       Alphabet,
+		Threshold:      m.Threshold,
+		MinConfidence:  m.MinConfidence,
+		SampleCounts:   m.SampleCounts(),
+		CreatedAt:      m.Info.CreatedAt,
+		TrainingImages: m.Info.TrainingImages,
+		Metrics:        m.Info.Metrics,
+	}
+	if _, err := w.Write(modelMagic); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	return enc.Encode(body{Chars: m.Chars, Network: m.Network})
+}
+
+// DumpModelFile将一个内存中的模型写入给定文件中，出错则返回错误。
+func DumpModelFile(m *Model, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := DumpModel(m, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadModel将从给定Reader中加载模型，并检查模型与当前程序是否兼容，出错则返回错误。
+// 也支持没有头部的旧格式，此时模型的Info.FormatVersion为0。
+func LoadModel(r io.Reader) (*Model, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, modelMagic) {
+		return loadLegacyModel(data)
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(data[len(modelMagic):]))
+	h := header{}
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("模型文件头部损坏：%s", err.Error())
+	}
+	if err := h.checkCompatible(); err != nil {
+		return nil, err
+	}
+	b := body{}
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("模型文件主体损坏：%s", err.Error())
+	}
+
+	m := &Model{
+		Version:       h.FeatureVersion,
+		Threshold:     h.Threshold,
+		MinConfidence: h.MinConfidence,
+		Chars:         b.Chars,
+		Network:       b.Network,
+		Info: ModelInfo{
+			FormatVersion:  h.FormatVersion,
+			CreatedAt:      h.CreatedAt,
+			TrainingImages: h.TrainingImages,
+			Metrics:        h.Metrics,
+		},
+	}
+	if m.Chars == nil {
+		m.Chars = map[rune]*modelElement{}
+	}
+	for char, samples := range m.SampleCounts() {
+		if h.SampleCounts[char] != samples {
+			return nil, fmt.Errorf("模型文件损坏：字符%c的样本数目与头部记录的不一致", char)
+		}
+	}
+	if m.Network != nil && !m.Network.valid() {
+		return nil, fmt.Errorf("模型文件损坏：神经网络的结构与当前程序不一致")
+	}
+	return m, nil
+}
+
+// checkCompatible检查模型文件能否被当前程序使用。
+func (h *header) checkCompatible() error {
+	if h.FormatVersion > FormatVersion {
+		return fmt.Errorf("模型文件格式版本为%d，当前程序只支持到%d，请升级程序", h.FormatVersion, FormatVersion)
+	}
+	if h.FeatureVersion > FeatureVersion {
+		return fmt.Errorf("模型的特征版本为%d，当前程序只支持到%d，请升级程序", h.FeatureVersion, FeatureVersion)
+	}
+	if h.FeatureVersion == FeatureVersion && h.FeatureType != FeatureType {
+		return fmt.Errorf("模型的特征类型%s与当前程序的%s不一致", h.FeatureType, FeatureType)
+	}
+	for char := range h.SampleCounts {
+		if !strings.ContainsRune(Alphabet, char) {
+			return fmt.Errorf("模型包含字母表以外的字符%c", char)
+		}
+	}
+	return nil
+}
+
+// loadLegacyModel加载没有头部的旧格式模型：先试着按没有元数据的Model结构解码，
+// 再试着按最早的、只有均值数据的map解码。
+func loadLegacyModel(data []byte) (*Model, error) {
+	m := &Model{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(m); err != nil {
+		legacy := map[rune]*modelElement{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err != nil {
+			return nil, err
+		}
+		m = &Model{Version: 0, Chars: legacy}
+	}
+
+	// 旧格式没有元数据，但每张训练图片恰好贡献4个字符样本。
+	m.Info = ModelInfo{}
+	for _, samples := range m.SampleCounts() {
+		m.Info.TrainingImages += samples
+	}
+	m.Info.TrainingImages /= 4
+	return m, nil
+}
+
+// LoadModelFile将从给定文件中加载模型，出错则返回错误。
+func LoadModelFile(filename string) (*Model, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadModel(f)
+}
@@ -0,0 +1,100 @@
+package captcha
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// SynthWidth和SynthHeight是生成的验证码的尺寸。
+	SynthWidth    = 100
+	SynthHeight   = 36
+	synthFontSize = 24
+)
+
+// Generator用确定的随机种子生成仿cas风格的验证码：4位小写字母和数字，浅色背景，
+// 中等亮度的字符，字符下层固定两条很暗的干扰线。种子相同时生成的验证码序列完全相同，
+// 可以离线地训练模型、检查分割的效果。Generator不能被多个协程并发使用。
+type Generator struct {
+	rng  *rand.Rand
+	face font.Face
+}
+
+// NewGenerator用给定的随机种子新建一个生成器。
+func NewGenerator(seed int64) (*Generator, error) {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: synthFontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{rng: rand.New(rand.NewSource(seed)), face: face}, nil
+}
+
+// Next生成一张随机内容的验证码，返回图片及其内容。
+func (g *Generator) Next() (image.Image, string) {
+	text := make([]byte, 4)
+	for i := range text {
+		text[i] = Alphabet[g.rng.Intn(len(Alphabet))]
+	}
+	return g.Render(string(text)), string(text)
+}
+
+// Render把给定的内容画成一张验证码。
+func (g *Generator) Render(text string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, SynthWidth, SynthHeight))
+
+	// 背景是接近白色的浅色，RGB范数远高于默认阈值的上界。
+	bg := color.RGBA{uint8(220 + g.rng.Intn(36)), uint8(220 + g.rng.Intn(36)), uint8(220 + g.rng.Intn(36)), 255}
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	// 两条干扰线在字符下层，几乎是黑色，RGB范数低于默认阈值的下界。
+	for i := 0; i < 2; i++ {
+		c := color.RGBA{uint8(g.rng.Intn(12)), uint8(g.rng.Intn(12)), uint8(g.rng.Intn(12)), 255}
+		y0 := 4 + g.rng.Float64()*float64(SynthHeight-8)
+		y1 := 4 + g.rng.Float64()*float64(SynthHeight-8)
+		drawLine(img, 0, y0, float64(SynthWidth-1), y1, c)
+	}
+
+	// 字符横向依次排开，每个字符的颜色、间距、高度略有不同。
+	x := 6 + g.rng.Intn(6)
+	for _, ch := range text {
+		c := glyphColor(g.rng)
+		y := 25 + g.rng.Intn(4)
+		d := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: g.face, Dot: fixed.P(x, y)}
+		d.DrawString(string(ch))
+		x = d.Dot.X.Round() + 3 + g.rng.Intn(4)
+	}
+	return img
+}
+
+// glyphColor随机选取字符的颜色，RGB范数在默认阈值的区间中间，加上抗锯齿的边缘也不会
+// 被误判为干扰线。
+func glyphColor(rng *rand.Rand) color.RGBA {
+	for {
+		c := color.RGBA{uint8(30 + rng.Intn(110)), uint8(30 + rng.Intn(110)), uint8(30 + rng.Intn(110)), 255}
+		norm := math.Sqrt(float64(c.R)*float64(c.R) + float64(c.G)*float64(c.G) + float64(c.B)*float64(c.B))
+		if norm >= 90 && norm <= 200 {
+			return c
+		}
+	}
+}
+
+// drawLine画一条宽度为1像素的直线。
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		img.SetRGBA(int(math.Round(x0+(x1-x0)*t)), int(math.Round(y0+(y1-y0)*t)), c)
+	}
+}
@@ -0,0 +1,69 @@
+package captcha
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestGeneratorDeterministic(t *testing.T) {
+	a, labelsA := synthSamples(t, 7, 5)
+	b, labelsB := synthSamples(t, 7, 5)
+	for i := range a {
+		if labelsA[i] != labelsB[i] {
+			t.Fatalf("相同种子的第%d张验证码内容为%s和%s", i, labelsA[i], labelsB[i])
+		}
+		var bufA, bufB bytes.Buffer
+		png.Encode(&bufA, a[i])
+		png.Encode(&bufB, b[i])
+		if !bytes.Equal(bufA.Bytes(), bufB.Bytes()) {
+			t.Fatalf("相同种子的第%d张验证码图片不同", i)
+		}
+	}
+}
+
+// TestGeneratedRates用一个种子生成的验证码训练，在另一个种子生成的验证码上评测，
+// 检查分割成功率和识别正确率。生成器或分割、特征的改动让效果明显变差时，这个测试会失败。
+func TestGeneratedRates(t *testing.T) {
+	d, err := OpenDataset(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, labels := synthSamples(t, 2, 200)
+	for i := range images {
+		if _, err := d.Add(images[i], labels[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Evaluate(NewRecognizer(synthModel(t, 1, 200)), d, d.Labeled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.NumImages != len(images) {
+		t.Fatalf("评测了%d张图片，应为%d张", r.NumImages, len(images))
+	}
+	t.Logf("分割成功率%.2f%%，等宽切分%.2f%%，单字符正确率%.2f%%，整体正确率%.2f%%",
+		100*r.SegmentationRate(), 100*r.FallbackRate(), 100*r.CharAccuracy(), 100*r.Accuracy())
+	if r.SegmentationRate() < 0.98 {
+		t.Errorf("分割成功率%.2f%%低于98%%", 100*r.SegmentationRate())
+	}
+	if r.CharAccuracy() < 0.98 {
+		t.Errorf("单字符正确率%.2f%%低于98%%", 100*r.CharAccuracy())
+	}
+	if r.Accuracy() < 0.95 {
+		t.Errorf("整体正确率%.2f%%低于95%%", 100*r.Accuracy())
+	}
+}
+
+func TestRenderSegments(t *testing.T) {
+	g, err := NewGenerator(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"0000", "mmww", "iljt", "a1b2"} {
+		if _, exact := segmentExact(g.Render(text), defaultThreshold); !exact {
+			t.Errorf("%s没有恰好分出4个字符", text)
+		}
+	}
+}
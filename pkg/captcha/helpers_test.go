@@ -0,0 +1,32 @@
+package captcha
+
+import (
+	"image"
+	"testing"
+)
+
+// synthSamples用给定的种子生成n张验证码及其内容。
+func synthSamples(tb testing.TB, seed int64, n int) ([]image.Image, []string) {
+	tb.Helper()
+	g, err := NewGenerator(seed)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	images := make([]image.Image, n)
+	labels := make([]string, n)
+	for i := range images {
+		images[i], labels[i] = g.Next()
+	}
+	return images, labels
+}
+
+// synthModel用生成的验证码训练一个模型。
+func synthModel(tb testing.TB, seed int64, n int) *Model {
+	tb.Helper()
+	m := NewModel()
+	images, labels := synthSamples(tb, seed, n)
+	for i := range images {
+		m.AddTrainingData(images[i], labels[i])
+	}
+	return m
+}
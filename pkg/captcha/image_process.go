@@ -7,102 +7,128 @@ import (
 	"io"
 )
 
-// DebugDenoise将提取给定的验证码图片中字符的部分，将提取出的像素描为纯红色，
-// 数据写入outData中，格式为PNG。若错误则返回错误。
-func DebugDenoise(captcha image.Image, outData io.Writer) error {
+// bitmap是验证码的前景位图，按行存储，pix[y*w+x]为true表示该像素属于有效字符。
+type bitmap struct {
+	w, h int
+	pix  []bool
+}
+
+// newBitmap用给定的阈值逐像素检测验证码图片，得到前景位图。
+func newBitmap(captcha image.Image, th Threshold) *bitmap {
 	bounds := captcha.Bounds()
-	nw := image.NewRGBA(bounds)
-	for i := 0; i < bounds.Dx(); i++ {
-		for j := 0; j < bounds.Dy(); j++ {
-			c := captcha.At(i, j)
-			if checkValid(c) {
-				nw.SetRGBA(i, j, color.RGBA{255, 0, 0, 255})
-			} else {
-				r, g, b, _ := c.RGBA()
-				nw.SetRGBA(i, j, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255})
+	b := &bitmap{w: bounds.Dx(), h: bounds.Dy()}
+	b.pix = make([]bool, b.w*b.h)
+	forEachRGB(captcha, func(x, y int, r, g, bl uint32) {
+		b.pix[y*b.w+x] = th.contains(r, g, bl)
+	})
+	return b
+}
+
+// forEachRGB按行遍历图片的每个像素，给出相对于左上角的坐标以及16位RGB分量。
+// 对*image.YCbCr（JPEG解码的结果）和*image.RGBA（不带透明通道的真彩色PNG解码的结果，
+// 以及Generator生成的图片）直接读取像素数组，避免每个像素都经过At()分配内存；其他类型
+// （比如带透明通道的PNG解码得到的*image.NRGBA）逐像素调用At()。
+func forEachRGB(captcha image.Image, f func(x, y int, r, g, b uint32)) {
+	bounds := captcha.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	switch img := captcha.(type) {
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				yi := img.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := img.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+				f(x, y, uint32(r)*0x101, uint32(g)*0x101, uint32(b)*0x101)
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			row := img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				p := row[x*4 : x*4+3]
+				f(x, y, uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101)
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, b, _ := captcha.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				f(x, y, r, g, b)
 			}
 		}
 	}
+}
 
-	nwImage := nw.SubImage(bounds)
-	if err := png.Encode(outData, nwImage); err != nil {
-		return err
-	}
-	return nil
+// DebugDenoise用识别器当前模型的阈值提取给定的验证码图片中字符的部分，将提取出的像素
+// 描为纯红色，数据写入outData中，格式为PNG。若错误则返回错误。
+func (r *Recognizer) DebugDenoise(captcha image.Image, outData io.Writer) error {
+	return debugDenoise(captcha, r.classifier().threshold, outData)
 }
 
-// denoiseAndSplit对验证码图像进行去噪处理，并分割成若干个字符，返回表示这几个
-// 字符的切片，每个切片元素是一系列坐标点。
-func denoiseAndSplit(captcha image.Image) [][]image.Point {
-	ret := make([][]image.Point, 0, 4)
+// DebugDenoise与Recognizer.DebugDenoise相同，但使用的是这个模型的阈值。
+func (m *Model) DebugDenoise(captcha image.Image, outData io.Writer) error {
+	return debugDenoise(captcha, m.threshold(), outData)
+}
 
+// debugDenoise见DebugDenoise。
+func debugDenoise(captcha image.Image, th Threshold, outData io.Writer) error {
 	bounds := captcha.Bounds()
-	visited := map[int]struct{}{}
-	for i := 0; i < bounds.Dx(); i++ {
-		for j := 0; j < bounds.Dy(); j++ {
-			points := bfs(captcha, i, j, visited)
-			if points != nil {
-				ret = append(ret, points)
-			}
+	nw := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	forEachRGB(captcha, func(x, y int, r, g, b uint32) {
+		if th.contains(r, g, b) {
+			nw.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+		} else {
+			nw.SetRGBA(x, y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255})
 		}
-	}
+	})
 
-	return ret
+	return png.Encode(outData, nw)
 }
 
-// checkValid检查给定的颜色是否属于验证码中的有效字符。
-func checkValid(c color.Color) bool {
-	r, g, b, _ := c.RGBA()
-	e1, e2, e3 := uint(r), uint(g), uint(b)
-	sqrsum := e1*e1 + e2*e2 + e3*e3
-	return sqrsum > 80000000 && sqrsum < 5000000000
+// denoiseAndSplit用给定的阈值对验证码图像进行去噪处理，并分割成若干个字符，返回表示这几个
+// 字符的切片，每个切片元素是一系列坐标点。字符按最左侧像素从左到右排列。
+func denoiseAndSplit(captcha image.Image, th Threshold) [][]image.Point {
+	return newBitmap(captcha, th).components()
 }
 
-// bfs对给定的单元格作为起点开始BFS，返回这个连通块的所有坐标。如果起点不属于有效字符，
-// 则返回nil。
-func bfs(captcha image.Image, initialX, initialY int, visited map[int]struct{}) []image.Point {
-	f := func(x, y int) int {
-		return x*captcha.Bounds().Dy() + y
-	}
+// components用BFS找出位图中所有的8连通块。所有连通块的坐标共用一个切片，
+// 这个切片同时也是BFS的队列，因此整个过程只有常数次内存分配。
+func (b *bitmap) components() [][]image.Point {
+	ret := make([][]image.Point, 0, 8)
+	points := make([]image.Point, 0, b.w*b.h)
+	visited := make([]bool, len(b.pix))
 
-	if _, ok := visited[f(initialX, initialY)]; ok {
-		return nil
-	}
-	visited[f(initialX, initialY)] = struct{}{}
-	if !checkValid(captcha.At(initialX, initialY)) {
-		return nil
-	}
+	// 按列扫描，保证连通块按从左到右的顺序输出。
+	for x := 0; x < b.w; x++ {
+		for y := 0; y < b.h; y++ {
+			idx := y*b.w + x
+			if visited[idx] || !b.pix[idx] {
+				continue
+			}
 
-	ret := make([]image.Point, 0, 20)
-	que := make(chan image.Point, 100)
-	que <- image.Point{initialX, initialY}
-	for {
-		select {
-		case h := <-que:
-			ret = append(ret, h)
-			for k := 0; k < 8; k++ {
-				tx := h.X + deltaX[k]
-				ty := h.Y + deltaY[k]
-				if tx < 0 || tx > captcha.Bounds().Dx() || ty < 0 || ty > captcha.Bounds().Dy() {
-					continue
-				}
-				if _, ok := visited[f(tx, ty)]; ok {
-					continue
-				}
-				if !checkValid(captcha.At(tx, ty)) {
-					continue
-				}
-				visited[f(tx, ty)] = struct{}{}
-				select {
-				case que <- image.Point{tx, ty}:
-				// 这种情况应该是出问题了，理论上缓冲区应该足够大，不可能爆。
-				default:
-					return nil
+			start := len(points)
+			visited[idx] = true
+			points = append(points, image.Point{x, y})
+			for head := start; head < len(points); head++ {
+				h := points[head]
+				for k := 0; k < 8; k++ {
+					tx := h.X + deltaX[k]
+					ty := h.Y + deltaY[k]
+					if tx < 0 || tx >= b.w || ty < 0 || ty >= b.h {
+						continue
+					}
+					tidx := ty*b.w + tx
+					if visited[tidx] || !b.pix[tidx] {
+						continue
+					}
+					visited[tidx] = true
+					points = append(points, image.Point{tx, ty})
 				}
 			}
-
-		default:
-			return ret
+			ret = append(ret, points[start:len(points):len(points)])
 		}
 	}
+
+	return ret
 }
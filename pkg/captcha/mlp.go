@@ -0,0 +1,167 @@
+package captcha
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Network是一个单隐层的全连接神经网络（多层感知机），输入为模板特征向量，
+// 隐层激活函数为tanh，输出为字母表中每个字符的概率（softmax）。
+type Network struct {
+	Inputs  int
+	Hidden  int
+	Outputs int
+	// W1是Hidden*Inputs的矩阵，按行存储，W2是Outputs*Hidden的矩阵。
+	W1 []float64
+	B1 []float64
+	W2 []float64
+	B2 []float64
+}
+
+// newNetwork新建一个随机初始化的网络。
+func newNetwork(inputs, hidden, outputs int, rng *rand.Rand) *Network {
+	n := &Network{
+		Inputs:  inputs,
+		Hidden:  hidden,
+		Outputs: outputs,
+		W1:      make([]float64, hidden*inputs),
+		B1:      make([]float64, hidden),
+		W2:      make([]float64, outputs*hidden),
+		B2:      make([]float64, outputs),
+	}
+	// Xavier初始化。
+	s1 := math.Sqrt(6 / float64(inputs+hidden))
+	for i := range n.W1 {
+		n.W1[i] = (rng.Float64()*2 - 1) * s1
+	}
+	s2 := math.Sqrt(6 / float64(hidden+outputs))
+	for i := range n.W2 {
+		n.W2[i] = (rng.Float64()*2 - 1) * s2
+	}
+	return n
+}
+
+// valid检查网络的结构能否用于当前版本的特征向量和字母表。
+func (n *Network) valid() bool {
+	return n.Inputs == vectorLen && n.Outputs == len(Alphabet) && n.Hidden > 0 &&
+		len(n.W1) == n.Hidden*n.Inputs && len(n.B1) == n.Hidden &&
+		len(n.W2) == n.Outputs*n.Hidden && len(n.B2) == n.Outputs
+}
+
+// forward计算网络的前向传播，返回隐层输出和每个输出的概率。
+func (n *Network) forward(x []float64) ([]float64, []float64) {
+	h := make([]float64, n.Hidden)
+	for i := range h {
+		sum := n.B1[i]
+		row := n.W1[i*n.Inputs : (i+1)*n.Inputs]
+		for j, v := range x {
+			sum += row[j] * v
+		}
+		h[i] = math.Tanh(sum)
+	}
+
+	out := make([]float64, n.Outputs)
+	maxLogit := math.Inf(-1)
+	for i := range out {
+		sum := n.B2[i]
+		row := n.W2[i*n.Hidden : (i+1)*n.Hidden]
+		for j, v := range h {
+			sum += row[j] * v
+		}
+		out[i] = sum
+		maxLogit = math.Max(maxLogit, sum)
+	}
+	total := 0.0
+	for i := range out {
+		out[i] = math.Exp(out[i] - maxLogit)
+		total += out[i]
+	}
+	for i := range out {
+		out[i] /= total
+	}
+	return h, out
+}
+
+// step用一个样本做一次随机梯度下降，损失函数为交叉熵，返回该样本的损失。
+func (n *Network) step(x []float64, y int, rate float64) float64 {
+	h, out := n.forward(x)
+	loss := -math.Log(math.Max(out[y], 1e-12))
+
+	// 输出层的梯度为out-onehot(y)。
+	gradOut := out
+	gradOut[y] -= 1
+	gradHidden := make([]float64, n.Hidden)
+	for i, g := range gradOut {
+		row := n.W2[i*n.Hidden : (i+1)*n.Hidden]
+		for j := range row {
+			gradHidden[j] += g * row[j]
+			row[j] -= rate * g * h[j]
+		}
+		n.B2[i] -= rate * g
+	}
+	for i, g := range gradHidden {
+		g *= 1 - h[i]*h[i]
+		row := n.W1[i*n.Inputs : (i+1)*n.Inputs]
+		for j, v := range x {
+			row[j] -= rate * g * v
+		}
+		n.B1[i] -= rate * g
+	}
+	return loss
+}
+
+// TrainNetwork用模型中保存的模板训练一个神经网络，存入m.Network。hidden为隐层的
+// 神经元数目，epochs为遍历全部模板的轮数，seed决定初始化和打乱的顺序，返回最后一轮的平均损失。
+func (m *Model) TrainNetwork(hidden, epochs int, seed int64) (float64, error) {
+	if m.Version != FeatureVersion {
+		return 0, fmt.Errorf("模型的特征版本为%d，没有可以训练神经网络的模板", m.Version)
+	}
+
+	xs := [][]float64{}
+	ys := []int{}
+	for char, elem := range m.Chars {
+		y := strings.IndexRune(Alphabet, char)
+		if y == -1 {
+			continue
+		}
+		for _, vec := range elem.Templates {
+			if len(vec) == vectorLen {
+				xs = append(xs, vec)
+				ys = append(ys, y)
+			}
+		}
+	}
+	if len(xs) == 0 {
+		return 0, fmt.Errorf("模型中没有模板")
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	n := newNetwork(vectorLen, hidden, len(Alphabet), rng)
+	order := rng.Perm(len(xs))
+	loss := 0.0
+	for epoch := 0; epoch < epochs; epoch++ {
+		// 学习率随轮数线性衰减。
+		rate := 0.05 * (1 - float64(epoch)/float64(epochs)*0.9)
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		loss = 0
+		for _, i := range order {
+			loss += n.step(xs[i], ys[i], rate)
+		}
+		loss /= float64(len(xs))
+	}
+
+	m.Network = n
+	return loss, nil
+}
+
+// rank用神经网络对一个特征向量打分，候选的概率即网络的输出，距离为概率的负对数。
+func (n *Network) rank(vec []float64) []Candidate {
+	_, out := n.forward(vec)
+	ret := make([]Candidate, 0, len(out))
+	for i, p := range out {
+		ret = append(ret, Candidate{Char: rune(Alphabet[i]), Distance: -math.Log(math.Max(p, 1e-300)), Probability: p})
+	}
+	return ret
+}
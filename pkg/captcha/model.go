@@ -1,101 +1,127 @@
 package captcha
 
 import (
-	"encoding/gob"
 	"image"
-	"io"
-	"os"
+	"time"
 )
 
 type modelElement struct {
 	Samples    int
 	SumFeature feature
+	// Templates是每个训练样本的特征向量，版本为Model.Version，用于k近邻分类。
+	Templates [][]float64
 }
 
-type Model map[rune]*modelElement
+// Model是OCR模型。Version是Templates所用的特征版本，与FeatureVersion不一致时，
+// 模板将被忽略，只使用由SumFeature得到的均值模型。Threshold是从训练数据中学到的
+// 前景判定规则，为nil时使用defaultThreshold。Network是用模板训练出来的神经网络，
+// 没有训练过则为nil。MinConfidence是为各种分类器（键为KindKNN等）标定的提交识别结果所需的
+// 最低置信度，没有标定过的分类器使用默认值。Info是模型文件中记录的元数据。
+type Model struct {
+	Version       int
+	Threshold     *Threshold
+	MinConfidence map[string]float64
+	Chars         map[rune]*modelElement
+	Network       *Network
+	Info          ModelInfo
+}
+
+// NewModel新建一个空模型。
+func NewModel() *Model {
+	return &Model{
+		Version: FeatureVersion,
+		Chars:   map[rune]*modelElement{},
+		Info:    ModelInfo{FormatVersion: FormatVersion, CreatedAt: time.Now()},
+	}
+}
 
 // AddTrainingData新增一张验证码图片的数据，返回是否成功。
-func (m Model) AddTrainingData(captchaImage image.Image, labels string) bool {
+func (m *Model) AddTrainingData(captchaImage image.Image, labels string) bool {
 	if len(labels) != 4 {
 		return false
 	}
 
-	chars := denoiseAndSplit(captchaImage)
+	chars := segment(captchaImage, m.threshold())
 	if chars == nil {
 		return false
 	}
-	feats := make([]*feature, 0, 4)
-	for _, char := range chars {
-		// 连通块过小则为噪声。
-		if len(char) <= 15 {
-			continue
-		}
-		// 已经有4位字符了，再来就是有问题。
-		if len(feats) == 4 {
-			return false
-		}
-
-		feat := extractFeatures(char)
-		if feat == nil {
-			return false
-		}
-
-		feats = append(feats, feat)
-	}
-
-	if len(feats) != 4 {
-		return false
-	}
 
 	for i := 0; i < 4; i++ {
-		feat := feats[i]
+		feat := extractFeatures(chars[i])
 		label := rune(labels[i])
-		if _, ok := m[label]; !ok {
-			m[label] = &modelElement{}
+		if _, ok := m.Chars[label]; !ok {
+			m.Chars[label] = &modelElement{}
 		}
 
-		m[label].Samples++
-		sum := &m[label].SumFeature
+		elem := m.Chars[label]
+		elem.Samples++
+		sum := &elem.SumFeature
 		sum.Width += feat.Width
 		sum.Height += feat.Height
 		sum.N += feat.N
 		for j := range sum.Numbers {
 			sum.Numbers[j] += feat.Numbers[j]
 		}
+		// 旧版本的模型只能继续累加均值，不能混入新版本的模板。
+		if m.Version == FeatureVersion {
+			elem.Templates = append(elem.Templates, extractVector(chars[i]))
+		}
 	}
+	m.Info.TrainingImages++
 	return true
 }
 
-// DumpModel将一个内存中的模型写入给定Writer中，出错则返回错误。
-func DumpModel(m Model, w io.Writer) error {
-	enc := gob.NewEncoder(w)
-	return enc.Encode(m)
+// clone复制模型，之后向副本添加训练数据不会影响原模型。模板本身不会被修改，因此只复制
+// 切片头，并限制容量，让副本追加模板时重新分配。
+func (m *Model) clone() *Model {
+	c := *m
+	c.Chars = make(map[rune]*modelElement, len(m.Chars))
+	for char, elem := range m.Chars {
+		e := *elem
+		e.Templates = elem.Templates[:len(elem.Templates):len(elem.Templates)]
+		c.Chars[char] = &e
+	}
+	if m.MinConfidence != nil {
+		c.MinConfidence = make(map[string]float64, len(m.MinConfidence))
+		for kind, v := range m.MinConfidence {
+			c.MinConfidence[kind] = v
+		}
+	}
+	return &c
 }
 
-// DumpModel将一个内存中的模型写入给定文件中，出错则返回错误。
-func DumpModelFile(m Model, filename string) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
+// threshold返回模型使用的前景判定规则。
+func (m *Model) threshold() Threshold {
+	if m.Threshold == nil {
+		return defaultThreshold
 	}
-	return DumpModel(m, f)
+	return *m.Threshold
 }
 
-// LoadModel将从给定Reader中加载模型，出错则返回错误。
-func LoadModel(r io.Reader) (Model, error) {
-	dec := gob.NewDecoder(r)
-	m := Model{}
-	if err := dec.Decode(&m); err != nil {
-		return nil, err
+// means计算模型中每个字符的平均特征。
+func (m *Model) means() map[rune]feature {
+	ret := make(map[rune]feature, len(m.Chars))
+	for char, modelElement := range m.Chars {
+		s := float64(modelElement.Samples)
+		feat := feature{}
+		sum := modelElement.SumFeature
+		feat.Width = sum.Width / s
+		feat.Height = sum.Height / s
+		feat.N = sum.N / s
+		for i, num := range sum.Numbers {
+			feat.Numbers[i] = num / s
+		}
+
+		ret[char] = feat
 	}
-	return m, nil
+	return ret
 }
 
-// LoadModelFile将从给定文件中加载模型，出错则返回错误。
-func LoadModelFile(filename string) (Model, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// SampleCounts返回模型中每个字符的训练样本数目。
+func (m *Model) SampleCounts() map[rune]int {
+	ret := make(map[rune]int, len(m.Chars))
+	for char, elem := range m.Chars {
+		ret[char] = elem.Samples
 	}
-	return LoadModel(f)
+	return ret
 }
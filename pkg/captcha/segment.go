@@ -0,0 +1,227 @@
+package captcha
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	// numChars是每张验证码的字符数目。
+	numChars = 4
+	// noisePixels是噪声连通块的最大像素数。
+	noisePixels = 15
+	// maxMergedWidth是合并碎片后允许的最大宽度，单位为期望字宽。
+	maxMergedWidth = 1.3
+	// minSplitWidth是连通块宽度至少为期望字宽的多少倍时，才认为它是粘连的多个字符。
+	// 期望字宽包含了字符间的空隙，因此两个粘连的字符一般只有期望字宽的1.3倍左右。
+	minSplitWidth = 1.15
+)
+
+// glyph是一个候选字符，记录了它的坐标以及外接矩形的左右边界。
+type glyph struct {
+	points      []image.Point
+	left, right int
+}
+
+func newGlyph(points []image.Point) glyph {
+	left, right, _, _ := bounds(points)
+	return glyph{points: points, left: left, right: right}
+}
+
+func (g glyph) width() int {
+	return g.right - g.left + 1
+}
+
+// segment用给定的阈值把验证码去噪分割成字符，按从左到右的顺序返回，分割不出4个字符时返回nil。
+// 连通块恰好是4个时直接返回；多于4个时，说明有字符被干扰线断开了，把水平方向上
+// 靠在一起的碎片合并；少于4个时，说明有字符粘连在一起，按竖直投影和期望字宽拆开
+// 过宽的连通块。以上都不行时，退回到把前景按等宽切成4份。
+func segment(captcha image.Image, th Threshold) [][]image.Point {
+	chars, _ := segmentExact(captcha, th)
+	return chars
+}
+
+// segmentExact与segment相同，另外返回是否不靠等宽切分就分出了4个字符。等宽切分几乎总能
+// 得到4份，因此评测分割效果时只能看这一项。
+func segmentExact(captcha image.Image, th Threshold) ([][]image.Point, bool) {
+	// 把有效字符抠出来，连通块过小则为噪声。
+	glyphs := make([]glyph, 0, numChars)
+	for _, char := range denoiseAndSplit(captcha, th) {
+		if len(char) > noisePixels {
+			glyphs = append(glyphs, newGlyph(char))
+		}
+	}
+	if len(glyphs) == 0 {
+		return nil, false
+	}
+
+	// 期望字宽取前景的总宽度除以字符数目。
+	left, right := glyphs[0].left, glyphs[0].right
+	for _, g := range glyphs {
+		left = minInt(left, g.left)
+		right = maxInt(right, g.right)
+	}
+	expected := float64(right-left+1) / numChars
+
+	if len(glyphs) > numChars {
+		glyphs = mergeFragments(glyphs, expected)
+	}
+	if len(glyphs) < numChars {
+		glyphs = splitTouching(glyphs, expected)
+	}
+	exact := len(glyphs) == numChars
+	if !exact {
+		glyphs = sliceFixedWidth(glyphs, left, right)
+		if glyphs == nil {
+			return nil, false
+		}
+	}
+
+	ret := make([][]image.Point, 0, numChars)
+	for _, g := range glyphs {
+		ret = append(ret, g.points)
+	}
+	return ret, exact
+}
+
+// mergeFragments不断地合并水平方向上重叠最多的一对相邻碎片，直到剩下4个字符，
+// 或者再合并就会超过期望字宽为止。
+func mergeFragments(glyphs []glyph, expected float64) []glyph {
+	for len(glyphs) > numChars {
+		sort.Slice(glyphs, func(i, j int) bool { return glyphs[i].left < glyphs[j].left })
+
+		best, bestOverlap := -1, math.MinInt32
+		for i := 0; i+1 < len(glyphs); i++ {
+			a, b := glyphs[i], glyphs[i+1]
+			merged := maxInt(a.right, b.right) - minInt(a.left, b.left) + 1
+			if float64(merged) > maxMergedWidth*expected {
+				continue
+			}
+			// 重叠为负数表示中间有空隙，空隙最多只能有1个像素。
+			overlap := minInt(a.right, b.right) - maxInt(a.left, b.left) + 1
+			if overlap >= -1 && overlap > bestOverlap {
+				best, bestOverlap = i, overlap
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		points := make([]image.Point, 0, len(glyphs[best].points)+len(glyphs[best+1].points))
+		points = append(points, glyphs[best].points...)
+		points = append(points, glyphs[best+1].points...)
+		glyphs[best] = newGlyph(points)
+		glyphs = append(glyphs[:best+1], glyphs[best+2:]...)
+	}
+	return glyphs
+}
+
+// splitTouching不断地把最宽的连通块按竖直投影拆开，直到有4个字符，或者最宽的
+// 连通块也不够宽为止。
+func splitTouching(glyphs []glyph, expected float64) []glyph {
+	for len(glyphs) < numChars {
+		widest := 0
+		for i, g := range glyphs {
+			if g.width() > glyphs[widest].width() {
+				widest = i
+			}
+		}
+		g := glyphs[widest]
+		if float64(g.width()) < minSplitWidth*expected {
+			break
+		}
+
+		parts := int(math.Round(float64(g.width()) / expected))
+		parts = maxInt(2, minInt(parts, numChars-len(glyphs)+1))
+		pieces := splitByProjection(g, parts, expected)
+		if pieces == nil {
+			break
+		}
+
+		glyphs = append(glyphs[:widest], append(pieces, glyphs[widest+1:]...)...)
+	}
+
+	sort.Slice(glyphs, func(i, j int) bool { return glyphs[i].left < glyphs[j].left })
+	return glyphs
+}
+
+// splitByProjection把一个连通块拆成parts份。每个切分点在等分点附近三分之一字宽的
+// 范围内，选竖直投影（该列的像素数）最小的那一列。拆出的某份过小时返回nil。
+func splitByProjection(g glyph, parts int, expected float64) []glyph {
+	proj := make([]int, g.width())
+	for _, p := range g.points {
+		proj[p.X-g.left]++
+	}
+
+	cuts := make([]int, 0, parts+1)
+	cuts = append(cuts, g.left)
+	radius := int(expected / 3)
+	for i := 1; i < parts; i++ {
+		target := g.left + i*g.width()/parts
+		best := target
+		for x := target - radius; x <= target+radius; x++ {
+			if x <= cuts[len(cuts)-1] || x > g.right {
+				continue
+			}
+			if proj[x-g.left] < proj[best-g.left] {
+				best = x
+			}
+		}
+		cuts = append(cuts, best)
+	}
+	cuts = append(cuts, g.right+1)
+
+	return slicePoints(g.points, cuts)
+}
+
+// sliceFixedWidth把所有连通块的像素合在一起，在[left, right]范围内按等宽切成4份。
+func sliceFixedWidth(glyphs []glyph, left, right int) []glyph {
+	points := []image.Point{}
+	for _, g := range glyphs {
+		points = append(points, g.points...)
+	}
+
+	cuts := make([]int, 0, numChars+1)
+	for i := 0; i < numChars; i++ {
+		cuts = append(cuts, left+i*(right-left+1)/numChars)
+	}
+	cuts = append(cuts, right+1)
+
+	return slicePoints(points, cuts)
+}
+
+// slicePoints按切分点把坐标分成len(cuts)-1份，第i份为横坐标在[cuts[i], cuts[i+1])中的点。
+// 某一份的像素数不超过噪声阈值时返回nil。
+func slicePoints(points []image.Point, cuts []int) []glyph {
+	buckets := make([][]image.Point, len(cuts)-1)
+	for _, p := range points {
+		i := sort.SearchInts(cuts, p.X+1) - 1
+		if i >= 0 && i < len(buckets) {
+			buckets[i] = append(buckets[i], p)
+		}
+	}
+
+	ret := make([]glyph, 0, len(buckets))
+	for _, bucket := range buckets {
+		if len(bucket) <= noisePixels {
+			return nil
+		}
+		ret = append(ret, newGlyph(bucket))
+	}
+	return ret
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
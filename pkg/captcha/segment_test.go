@@ -0,0 +1,64 @@
+package captcha
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// blobs画出若干个宽度为width、间隔为gap的灰色竖条，背景为白色。
+func blobs(n, width, gap int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, n*(width+gap)+10, 30))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	gray := &image.Uniform{color.RGBA{128, 128, 128, 255}}
+	for i := 0; i < n; i++ {
+		x := 5 + i*(width+gap)
+		draw.Draw(img, image.Rect(x, 5, x+width, 25), gray, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestSegmentExact(t *testing.T) {
+	chars, exact := segmentExact(blobs(4, 10, 3), defaultThreshold)
+	if len(chars) != numChars || !exact {
+		t.Errorf("4个分开的字符分出了%d个，exact为%v", len(chars), exact)
+	}
+}
+
+func TestSegmentFallback(t *testing.T) {
+	// 5个相距2像素的竖条既不能合并，也不需要拆开，只能等宽切分。
+	chars, exact := segmentExact(blobs(5, 10, 2), defaultThreshold)
+	if len(chars) != numChars {
+		t.Fatalf("等宽切分应当分出4个字符，实际为%d个", len(chars))
+	}
+	if exact {
+		t.Error("等宽切分的结果不应当算作分割成功")
+	}
+	if segment(blobs(5, 10, 2), defaultThreshold) == nil {
+		t.Error("segment应当返回等宽切分的结果")
+	}
+}
+
+func TestEvaluateCountsFallback(t *testing.T) {
+	d, err := OpenDataset(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Add(blobs(4, 10, 3), "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Add(blobs(5, 10, 2), "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Evaluate(NewRecognizer(synthModel(t, 1, 20)), d, d.Labeled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.NumImages != 2 || r.NumSegmented != 1 || r.NumFallback != 1 {
+		t.Errorf("图片%d张，分割成功%d张，等宽切分%d张，应为2、1、1", r.NumImages, r.NumSegmented, r.NumFallback)
+	}
+	if r.SegmentationRate() != 0.5 {
+		t.Errorf("分割成功率为%f，应为0.5", r.SegmentationRate())
+	}
+}
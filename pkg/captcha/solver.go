@@ -0,0 +1,24 @@
+package captcha
+
+import (
+	"errors"
+	"image"
+)
+
+// ErrUnrecognized表示验证码无法识别，比如分割失败。
+var ErrUnrecognized = errors.New("验证码无法识别")
+
+// Solver是验证码求解器，给出验证码图片的识别结果。Recognizer是用统计模型（k近邻或均值模型）
+// 或者神经网络自动识别的实现，也可以是让人来输入的实现。
+type Solver interface {
+	Solve(captcha image.Image) (*Result, error)
+}
+
+// Solve实现Solver接口，候选只保留最优的一个。
+func (r *Recognizer) Solve(captcha image.Image) (*Result, error) {
+	res := r.RecognizeWithConfidence(captcha, 1)
+	if res == nil {
+		return nil, ErrUnrecognized
+	}
+	return res, nil
+}
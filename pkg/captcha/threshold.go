@@ -0,0 +1,110 @@
+package captcha
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// normBins是RGB范数直方图的格数，8位RGB的范数最大为sqrt(3)*255，约441.7。
+const normBins = 442
+
+// Threshold是前景像素（有效字符）的判定规则：16位RGB分量的平方和落在(Low, High)
+// 之间的像素即为前景。背景比字符亮，干扰线比字符暗，所以前景是中间的一段。
+type Threshold struct {
+	Low  uint64
+	High uint64
+}
+
+// defaultThreshold是手工调出来的判定规则，模型没有学习过阈值时使用。
+var defaultThreshold = Threshold{Low: 80000000, High: 5000000000}
+
+// contains检查给定的16位RGB分量是否属于前景。
+func (t Threshold) contains(r, g, b uint32) bool {
+	e1, e2, e3 := uint64(r), uint64(g), uint64(b)
+	sqrsum := e1*e1 + e2*e2 + e3*e3
+	return sqrsum > t.Low && sqrsum < t.High
+}
+
+// String把阈值换算成8位RGB的范数，便于阅读。
+func (t Threshold) String() string {
+	return fmt.Sprintf("8位RGB范数在(%.1f, %.1f)之间", math.Sqrt(float64(t.Low))/257, math.Sqrt(float64(t.High))/257)
+}
+
+// thresholdFromNorms由8位RGB范数的范围[low, high)得到阈值，low至少为1。
+func thresholdFromNorms(low, high int) Threshold {
+	l := uint64(low) * 257
+	h := uint64(high) * 257
+	return Threshold{Low: l*l - 1, High: h * h}
+}
+
+// LearnThreshold在给定的图片上学习前景像素的判定规则：统计所有像素RGB范数的直方图，
+// 用三类Otsu法找出使类间方差最大的两个分割点，把像素分为干扰线、字符、背景三类，
+// 取中间一类为前景。
+func LearnThreshold(images []image.Image) (Threshold, error) {
+	hist := make([]float64, normBins)
+	for _, img := range images {
+		forEachRGB(img, func(x, y int, r, g, b uint32) {
+			r8, g8, b8 := float64(r>>8), float64(g>>8), float64(b>>8)
+			hist[int(math.Sqrt(r8*r8+g8*g8+b8*b8))]++
+		})
+	}
+
+	// 前缀和，w[i]为前i格的像素数，mu[i]为前i格的范数之和。
+	w := make([]float64, normBins+1)
+	mu := make([]float64, normBins+1)
+	for i, n := range hist {
+		w[i+1] = w[i] + n
+		mu[i+1] = mu[i] + n*float64(i)
+	}
+	total := w[normBins]
+	if total == 0 {
+		return defaultThreshold, fmt.Errorf("没有可以学习阈值的像素")
+	}
+
+	// 三类分别为[0, t1)、[t1, t2)、[t2, normBins)，最大化sum(w_k * mean_k^2)等价于最大化类间方差。
+	classScore := func(from, to int) float64 {
+		n := w[to] - w[from]
+		if n == 0 {
+			return 0
+		}
+		s := mu[to] - mu[from]
+		return s * s / n
+	}
+	best, t1, t2 := -1.0, 0, 0
+	for i := 1; i < normBins-1; i++ {
+		for j := i + 1; j < normBins; j++ {
+			score := classScore(0, i) + classScore(i, j) + classScore(j, normBins)
+			if score > best {
+				best, t1, t2 = score, i, j
+			}
+		}
+	}
+
+	return thresholdFromNorms(t1, t2), nil
+}
+
+// adaptThreshold在训练图片上学习阈值，若用学到的阈值不靠等宽切分就能分出4个字符的图片
+// 不比默认阈值少，则采用学到的阈值。等宽切分几乎总能成功，所以不计入比较。只能在添加
+// 训练数据之前调用，否则已有的特征与阈值对不上。
+func (m *Model) adaptThreshold(images []image.Image) error {
+	learned, err := LearnThreshold(images)
+	if err != nil {
+		return err
+	}
+	if countExact(images, learned) >= countExact(images, defaultThreshold) {
+		m.Threshold = &learned
+	}
+	return nil
+}
+
+// countExact返回用给定阈值不靠等宽切分就能分出4个字符的图片数目。
+func countExact(images []image.Image, th Threshold) int {
+	n := 0
+	for _, img := range images {
+		if _, exact := segmentExact(img, th); exact {
+			n++
+		}
+	}
+	return n
+}
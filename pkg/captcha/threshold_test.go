@@ -0,0 +1,55 @@
+package captcha
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestCountExact(t *testing.T) {
+	images := []image.Image{blobs(4, 10, 3), blobs(5, 10, 2), blobs(4, 10, 3)}
+	if n := countExact(images, defaultThreshold); n != 2 {
+		t.Errorf("能分出4个字符的图片为%d张，应为2张（等宽切分的不算）", n)
+	}
+}
+
+// spotted画出4个颜色为char的竖条，背景为白色，右下角再画一个颜色为spot的小方块。
+func spotted(char, spot color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 62, 32))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	for i := 0; i < 4; i++ {
+		x := 5 + i*13
+		draw.Draw(img, image.Rect(x, 5, x+10, 25), &image.Uniform{char}, image.Point{}, draw.Src)
+	}
+	draw.Draw(img, image.Rect(58, 28, 60, 30), &image.Uniform{spot}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestAdaptThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   image.Image
+		adopted bool
+	}{
+		// 字符的范数约为329，超出了默认阈值的上限，只有学到的阈值能把它与背景、黑色的点分开。
+		{"浅色字符", spotted(color.Gray{190}, color.Black), true},
+		// 字符的范数约为61，在默认阈值之内；范数约为300的浅灰色点却成了范数居中的一类，
+		// 学到的阈值只会分出这个点，字符则被当作干扰线。
+		{"浅灰色点", spotted(color.Gray{35}, color.Gray{173}), false},
+	}
+	for _, tt := range tests {
+		images := []image.Image{tt.image, tt.image, tt.image}
+		m := NewModel()
+		if err := m.adaptThreshold(images); err != nil {
+			t.Fatal(err)
+		}
+		if adopted := m.Threshold != nil; adopted != tt.adopted {
+			t.Errorf("%s：是否采用学到的阈值为%v，应为%v", tt.name, adopted, tt.adopted)
+		}
+		// 采用的阈值应当能把每张图片恰好分出4个字符。
+		if n := countExact(images, m.threshold()); n != len(images) {
+			t.Errorf("%s：采用的阈值%s只分割成功%d张", tt.name, m.threshold(), n)
+		}
+	}
+}
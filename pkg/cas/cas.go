@@ -1,5 +1,5 @@
 /*
-cas包实现了鸭大cas系统的模拟登录。
+cas包实现了鸭大cas系统的模拟登录，以及用登录态申请服务票据、登录同一cas系统后面的其他服务。
 */
 package cas
 
@@ -7,98 +7,131 @@ import (
 	"fmt"
 	"image"
 	"image/jpeg"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
-const (
-	CAS_LOGIN_URL = "https://cas.sysu.edu.cn/cas/login"
-	CAS_CAPTCHA   = "https://cas.sysu.edu.cn/cas/captcha.jsp"
-)
+// Endpoints是一个cas系统的各个地址。其他学校的Apereo CAS系统只是地址不同。ValidateUrl可以
+// 省略，此时由LoginUrl推出。
+type Endpoints struct {
+	LoginUrl    string `json:"loginUrl"`
+	CaptchaUrl  string `json:"captchaUrl"`
+	ValidateUrl string `json:"validateUrl,omitempty"`
+}
 
-// LoginCas 用给定的用户名，密码，验证码来登录cas系统，注意登录前需要先
-// 获取一次验证码。返回登录态cookie，若登录失败则为nil。
-func LoginCas(username, password, captcha string, jsessionid *http.Cookie, fakeHeader map[string]string) (*http.Cookie, error) {
-	form := url.Values{
-		"username":    []string{username},
-		"password":    []string{password},
-		"captcha":     []string{captcha},
-		"_eventId":    []string{"submit"},
-		"geolocation": []string{""},
-		"execution":   []string{""},
+// serviceValidateUrl返回校验服务票据的地址，没有配置时把LoginUrl末尾的login换成serviceValidate。
+func (e Endpoints) serviceValidateUrl() (string, error) {
+	if e.ValidateUrl != "" {
+		return e.ValidateUrl, nil
+	}
+	u, err := url.Parse(e.LoginUrl)
+	if err != nil {
+		return "", err
 	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "login") + "serviceValidate"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// LoginCas 用给定的用户名，密码，验证码来登录e所指的cas系统，注意登录前需要先
+// 获取一次验证码。返回登录态cookie。登录失败时返回*LoginError，可以用errors.Is区分
+// ErrCaptcha、ErrCredentials、ErrLocked和ErrLoginFailed。
+func LoginCas(e Endpoints, username, password, captcha string, jsessionid *http.Cookie, fakeHeader map[string]string) (*http.Cookie, error) {
+	return login(http.DefaultClient, e, username, password, captcha, jsessionid, fakeHeader)
+}
 
-	// 找到HTML源码里的execution，登录表单需要用到。
-	req, err := http.NewRequest("GET", CAS_LOGIN_URL, nil)
+// login用hc登录e所指的cas系统，jsessionid不为nil时手动带上，否则由hc的cookie jar带上。
+func login(hc *http.Client, e Endpoints, username, password, captcha string, jsessionid *http.Cookie, fakeHeader map[string]string) (*http.Cookie, error) {
+	// 解析登录页面，找到登录表单的地址和隐藏字段，比如execution。
+	loginUrl, err := url.Parse(e.LoginUrl)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", e.LoginUrl, nil)
 	if err != nil {
 		return nil, err
 	}
 	addHeaders(req, fakeHeader)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := hc.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	bodyBytes, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	page, err := ParseLoginPage(resp.Body, loginUrl)
+	resp.Body.Close()
 	if err != nil {
 		return nil, err
 	}
-	body := string(bodyBytes)
-	start := strings.Index(body, "execution")
-	if start == -1 {
-		return nil, fmt.Errorf("登录页面HTML源码不包含execution")
+	if page.CaptchaRequired && captcha == "" {
+		return nil, &LoginError{Kind: ErrCaptcha, Message: "登录页面要求输入验证码"}
 	}
-	start += 18
-	end := start + 1
-	for end < len(body) && body[end] != '"' {
-		end++
+
+	form := url.Values{}
+	for k, v := range page.Hidden {
+		form[k] = v
 	}
-	if end == len(body) {
-		return nil, fmt.Errorf("登录页面HTML的execution不包含右引号")
+	if form.Get("_eventId") == "" {
+		form.Set("_eventId", "submit")
 	}
-	form["execution"][0] = body[start:end]
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("captcha", captcha)
 
 	// 构造请求的Header和Cookie
-	req, err = http.NewRequest("POST", CAS_LOGIN_URL, strings.NewReader(form.Encode()))
+	req, err = http.NewRequest("POST", page.Action, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	addHeaders(req, fakeHeader)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(jsessionid)
+	if jsessionid != nil {
+		req.AddCookie(jsessionid)
+	}
 
 	// 正式发起登录请求。
-	resp, err = http.DefaultClient.Do(req)
+	resp, err = hc.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	// 检查是否登录成功，若成功，则响应Cookie里有TGC
-	var tgc *http.Cookie = nil
 	for _, cookie := range resp.Cookies() {
 		if cookie.Name == "TGC" {
-			tgc = cookie
-			break
+			return cookie, nil
 		}
 	}
 
-	return tgc, nil
+	// 登录失败时cas系统会重新返回登录页面，页面上有错误信息。
+	page, _ = ParseLoginPage(resp.Body, resp.Request.URL)
+	if page == nil {
+		return nil, &LoginError{Kind: ErrLoginFailed}
+	}
+	return nil, newLoginError(page.Error)
+}
+
+// NewSessionAndGetRawCaptcha在e所指的cas系统上新起一个会话，获得验证码，返回这个验证码图片，
+// 以及此次会话的JSESSIONID。
+func NewSessionAndGetRawCaptcha(e Endpoints, fakeHeader map[string]string) (image.Image, *http.Cookie, error) {
+	return getRawCaptcha(http.DefaultClient, e, fakeHeader)
 }
 
-// NewSessionAndGetRawCaptcha新起一个会话，获得验证码，返回这个验证码图片，以及此次会话的JSESSIONID。
-func NewSessionAndGetRawCaptcha(fakeHeader map[string]string) (image.Image, *http.Cookie, error) {
-	req, err := http.NewRequest("GET", CAS_CAPTCHA, nil)
+// getRawCaptcha用hc获取e所指的cas系统的验证码，返回验证码图片和响应中的JSESSIONID。
+func getRawCaptcha(hc *http.Client, e Endpoints, fakeHeader map[string]string) (image.Image, *http.Cookie, error) {
+	req, err := http.NewRequest("GET", e.CaptchaUrl, nil)
 	if err != nil {
 		return nil, nil, err
 	}
 	addHeaders(req, fakeHeader)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := hc.Do(req)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("获取captcha失败：%s", resp.Status)
+	}
 
 	ret, err := jpeg.Decode(resp.Body)
 	if err != nil {
@@ -119,9 +152,18 @@ func NewSessionAndGetRawCaptcha(fakeHeader map[string]string) (image.Image, *htt
 	return ret, jsessionid, nil
 }
 
-// addHeaders伪造头部，用于通过安全检查，获取可用TGC。细节请看文档。
+// addHeaders伪造头部，用于通过安全检查，获取可用TGC。细节请看文档。Accept-Encoding除外，见skipHeader。
 func addHeaders(req *http.Request, fakeHeader map[string]string) {
 	for k, v := range fakeHeader {
-		req.Header.Add(k, v)
+		if !skipHeader(k) {
+			req.Header.Add(k, v)
+		}
 	}
 }
+
+// skipHeader判断伪造头部中的k是否不应该加到请求上。浏览器指纹带有Accept-Encoding，但请求中
+// 一旦有了这个头部，http.Transport就不再自动解压响应，gzip压缩的页面、验证码和票据校验结果
+// 都会原样交给调用者；不带时Transport会自己协商gzip并解压。
+func skipHeader(k string) bool {
+	return http.CanonicalHeaderKey(k) == "Accept-Encoding"
+}
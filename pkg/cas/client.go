@@ -0,0 +1,202 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultTgcLifetime是TGC没有Expires时假定的有效期，Apereo CAS默认的TGT有效期在两小时以上。
+const DefaultTgcLifetime = 2 * time.Hour
+
+// DefaultTimeout是客户端每个请求的超时，包括连接、重定向和读取响应。
+const DefaultTimeout = 30 * time.Second
+
+// ErrTicketRejected表示cas系统没有签发服务票据，通常是因为TGC已经失效，需要重新登录。
+var ErrTicketRejected = errors.New("cas系统拒绝签发服务票据")
+
+// Client是某个用户在一个cas系统上的会话，有自己的http.Client和cookie jar，缓存登录得到的TGC
+// 及其过期时间。TGC仍然有效时可以直接复用，不必再获取验证码、输入密码。Client可以被多个协程
+// 并发使用，但同一时刻只应有一个协程在登录，否则一个协程的NewSession会让另一个协程的登录失败。
+type Client struct {
+	// TgcLifetime是TGC没有Expires时假定的有效期，为0时使用DefaultTgcLifetime。
+	TgcLifetime time.Duration
+
+	endpoints  Endpoints
+	fakeHeader map[string]string
+
+	mutex sync.Mutex
+	// httpClient一经创建就不再修改，换cookie jar或代理时换成新的http.Client，
+	// 正在进行的请求仍使用旧的。
+	httpClient *http.Client
+	tgc        *http.Cookie
+	jsessionid *http.Cookie
+	expiry     time.Time
+}
+
+// NewClient在e所指的cas系统上新建一个客户端，所有请求都会带上fakeHeader。
+func NewClient(e Endpoints, fakeHeader map[string]string) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		endpoints:  e,
+		fakeHeader: fakeHeader,
+		httpClient: &http.Client{
+			Jar:     jar,
+			Timeout: DefaultTimeout,
+			// 不跟随重定向，登录成功的响应和服务票据都在重定向之前。
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// SetProxy让客户端之后的所有请求都经由代理u，支持http、https和socks5，u为nil时不用代理。
+func (c *Client) SetProxy(u *url.URL) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	if u != nil {
+		t.Proxy = http.ProxyURL(u)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	hc := *c.httpClient
+	hc.Transport = t
+	c.httpClient = &hc
+}
+
+// client返回客户端当前所用的http.Client。
+func (c *Client) client() *http.Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.httpClient
+}
+
+// transport返回客户端所用的http.RoundTripper。
+func (c *Client) transport() http.RoundTripper {
+	if t := c.client().Transport; t != nil {
+		return t
+	}
+	return http.DefaultTransport
+}
+
+// NewSession丢弃已有的登录态，新起一个会话并返回这个会话的验证码图片。
+func (c *Client) NewSession() (image.Image, error) {
+	jar, _ := cookiejar.New(nil)
+	c.mutex.Lock()
+	hc := *c.httpClient
+	hc.Jar = jar
+	c.httpClient = &hc
+	c.tgc, c.jsessionid, c.expiry = nil, nil, time.Time{}
+	c.mutex.Unlock()
+
+	img, jsessionid, err := getRawCaptcha(&hc, c.endpoints, c.fakeHeader)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.jsessionid = jsessionid
+	c.mutex.Unlock()
+	return img, nil
+}
+
+// Login用用户名、密码和NewSession得到的验证码的识别结果登录，成功后缓存TGC。失败时返回的错误
+// 与LoginCas相同。
+func (c *Client) Login(username, password, captcha string) error {
+	c.mutex.Lock()
+	jsessionid, hc := c.jsessionid, c.httpClient
+	c.mutex.Unlock()
+	if jsessionid == nil {
+		return fmt.Errorf("登录前需要先调用NewSession获取验证码")
+	}
+
+	tgc, err := login(hc, c.endpoints, username, password, captcha, nil, c.fakeHeader)
+	if err != nil {
+		return err
+	}
+
+	expiry := tgc.Expires
+	if expiry.IsZero() {
+		lifetime := c.TgcLifetime
+		if lifetime == 0 {
+			lifetime = DefaultTgcLifetime
+		}
+		expiry = time.Now().Add(lifetime)
+	}
+	c.mutex.Lock()
+	c.tgc, c.expiry = tgc, expiry
+	c.mutex.Unlock()
+	return nil
+}
+
+// Cookies返回缓存的TGC和JSESSIONID，还没有登录时TGC为nil。
+func (c *Client) Cookies() (tgc, jsessionid *http.Cookie) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.tgc, c.jsessionid
+}
+
+// Expiry返回缓存的TGC的过期时间，还没有登录时为零值。
+func (c *Client) Expiry() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.expiry
+}
+
+// ServiceTicket用缓存的TGC向cas系统申请访问service的服务票据。TGC失效时返回ErrTicketRejected。
+func (c *Client) ServiceTicket(service string) (string, error) {
+	c.mutex.Lock()
+	tgc := c.tgc
+	c.mutex.Unlock()
+	if tgc == nil {
+		return "", ErrTicketRejected
+	}
+
+	u, err := url.Parse(c.endpoints.LoginUrl)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("service", service)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	addHeaders(req, c.fakeHeader)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	// 签发成功时cas系统重定向到service，并在地址中带上ticket参数，否则重新返回登录页面。
+	location, err := resp.Location()
+	if err != nil {
+		return "", ErrTicketRejected
+	}
+	ticket := location.Query().Get("ticket")
+	if ticket == "" {
+		return "", ErrTicketRejected
+	}
+	return ticket, nil
+}
+
+// Valid检查缓存的TGC是否仍然可用：没有过期，并且cas系统仍然愿意用它签发service的服务票据。
+// 申请到的服务票据不会被使用，过一段时间后cas系统会自行销毁。
+func (c *Client) Valid(service string) bool {
+	c.mutex.Lock()
+	ok := c.tgc != nil && time.Now().Before(c.expiry)
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	_, err := c.ServiceTicket(service)
+	return err == nil
+}
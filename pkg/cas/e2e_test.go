@@ -0,0 +1,380 @@
+package cas_test
+
+import (
+	"errors"
+	"io"
+	"jksbx/internal/pkg/fakesite"
+	"jksbx/pkg/cas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHeader是通过假站点安全检查所需的伪造头部。
+var fakeHeader = map[string]string{
+	"User-Agent":      "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.127 Safari/537.36",
+	"Accept-Language": "zh-CN,zh;q=0.9",
+	"sec-ch-ua":       `" Not A;Brand";v="99", "Chromium";v="100", "Google Chrome";v="100"`,
+}
+
+// startSite启动一个有用户test:secret的假站点，返回站点、服务器和cas系统的地址。
+func startSite(t *testing.T) (*fakesite.Site, *httptest.Server, cas.Endpoints) {
+	t.Helper()
+	site, err := fakesite.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site.AddUser("test", "secret")
+	srv := site.Start()
+	t.Cleanup(srv.Close)
+	p, err := fakesite.Profile("fake", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return site, srv, p.Cas
+}
+
+// service返回假jksb系统用服务票据登录的地址。
+func service(srv *httptest.Server) string {
+	return srv.URL + "/infoplus/login"
+}
+
+// loginCas用LoginCas登录，验证码直接从假站点读出。
+func loginCas(t *testing.T, site *fakesite.Site, e cas.Endpoints, password string) (*http.Cookie, error) {
+	t.Helper()
+	_, jsessionid, err := cas.NewSessionAndGetRawCaptcha(e, fakeHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cas.LoginCas(e, "test", password, site.Captcha(jsessionid.Value), jsessionid, fakeHeader)
+}
+
+// newClient新建一个客户端并登录，验证码直接从假站点读出。
+func newClient(t *testing.T, site *fakesite.Site, e cas.Endpoints) *cas.Client {
+	t.Helper()
+	c := cas.NewClient(e, fakeHeader)
+	login(t, site, c)
+	return c
+}
+
+// login用客户端c登录，验证码直接从假站点读出。
+func login(t *testing.T, site *fakesite.Site, c *cas.Client) {
+	t.Helper()
+	if _, err := c.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	_, jsessionid := c.Cookies()
+	if err := c.Login("test", "secret", site.Captcha(jsessionid.Value)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginCas(t *testing.T) {
+	site, _, e := startSite(t)
+	tgc, err := loginCas(t, site, e, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tgc.Name != "TGC" || tgc.Value == "" {
+		t.Errorf("登录得到的cookie为%v，应为TGC", tgc)
+	}
+}
+
+func TestLoginCasErrors(t *testing.T) {
+	site, _, e := startSite(t)
+	if _, err := loginCas(t, site, e, "wrong"); !errors.Is(err, cas.ErrCredentials) {
+		t.Errorf("密码错误时返回%v，应为ErrCredentials", err)
+	}
+
+	_, jsessionid, err := cas.NewSessionAndGetRawCaptcha(e, fakeHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cas.LoginCas(e, "test", "secret", "????", jsessionid, fakeHeader); !errors.Is(err, cas.ErrCaptcha) {
+		t.Errorf("验证码错误时返回%v，应为ErrCaptcha", err)
+	}
+	// 验证码只能用一次，即使上一次输错了。
+	if _, err := cas.LoginCas(e, "test", "secret", site.Captcha(jsessionid.Value), jsessionid, fakeHeader); !errors.Is(err, cas.ErrCaptcha) {
+		t.Errorf("重复使用验证码时返回%v，应为ErrCaptcha", err)
+	}
+
+	_, jsessionid, err = cas.NewSessionAndGetRawCaptcha(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cas.LoginCas(e, "test", "secret", site.Captcha(jsessionid.Value), jsessionid, nil); err == nil {
+		t.Error("没有伪造头部时不应当登录成功")
+	}
+}
+
+func TestClientReusesTgc(t *testing.T) {
+	site, srv, e := startSite(t)
+	c := newClient(t, site, e)
+	tgc, _ := c.Cookies()
+	if tgc == nil || !c.Expiry().After(time.Now()) {
+		t.Fatalf("登录后TGC为%v，过期时间为%v", tgc, c.Expiry())
+	}
+
+	// 不重新登录，用同一个TGC反复申请服务票据。
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		if !c.Valid(service(srv)) {
+			t.Fatalf("第%d次检查时TGC已经无效", i+1)
+		}
+		ticket, err := c.ServiceTicket(service(srv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[ticket] {
+			t.Errorf("服务票据%s重复签发", ticket)
+		}
+		seen[ticket] = true
+	}
+
+	hc, err := c.ServiceClient(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hc.Get(srv.URL + "/infoplus/form/XNYQSB/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Request.URL.Path != "/infoplus/form/XNYQSB/start" {
+		t.Errorf("用服务票据登录后访问申报表得到%s %s", resp.Status, resp.Request.URL)
+	}
+
+	// NewSession丢弃登录态，之后不能再申请服务票据。
+	if _, err := c.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Valid(service(srv)) {
+		t.Error("NewSession之后TGC仍然有效")
+	}
+	if _, err := c.ServiceTicket(service(srv)); !errors.Is(err, cas.ErrTicketRejected) {
+		t.Errorf("NewSession之后申请服务票据返回%v，应为ErrTicketRejected", err)
+	}
+}
+
+func TestClientExpiry(t *testing.T) {
+	site, srv, e := startSite(t)
+	c := cas.NewClient(e, fakeHeader)
+	c.TgcLifetime = time.Millisecond
+	if _, err := c.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	_, jsessionid := c.Cookies()
+	if err := c.Login("test", "secret", site.Captcha(jsessionid.Value)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if c.Valid(service(srv)) {
+		t.Error("TGC过期之后仍然有效")
+	}
+}
+
+func TestClientLoginRequiresSession(t *testing.T) {
+	_, _, e := startSite(t)
+	if err := cas.NewClient(e, fakeHeader).Login("test", "secret", "abcd"); err == nil {
+		t.Error("没有调用NewSession时不应当登录成功")
+	}
+}
+
+func TestNewClientWithTgc(t *testing.T) {
+	site, srv, e := startSite(t)
+	tgc, err := loginCas(t, site, e, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cas.NewClientWithTgc(e, tgc, fakeHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Valid(service(srv)) {
+		t.Error("用LoginCas得到的TGC新建的客户端无效")
+	}
+
+	forged, err := cas.NewClientWithTgc(e, &http.Cookie{Name: "TGC", Value: "TGT-forged"}, fakeHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forged.Valid(service(srv)) {
+		t.Error("伪造的TGC不应当有效")
+	}
+}
+
+func TestValidateTicket(t *testing.T) {
+	site, srv, e := startSite(t)
+	c := newClient(t, site, e)
+	ticket, err := c.ServiceTicket(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := cas.ValidateTicket(e, service(srv), ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.User != "test" {
+		t.Errorf("票据所属的用户为%q，应为test", p.User)
+	}
+
+	// 票据只能用一次。
+	_, err = cas.ValidateTicket(e, service(srv), ticket)
+	var te *cas.TicketError
+	if !errors.As(err, &te) || te.Code != "INVALID_TICKET" || !errors.Is(err, cas.ErrInvalidTicket) {
+		t.Errorf("重复校验票据返回%v，应为INVALID_TICKET", err)
+	}
+
+	// 为别的service签发的票据不能通过校验。
+	ticket, err = c.ServiceTicket(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cas.ValidateTicket(e, srv.URL+"/other", ticket); !errors.Is(err, cas.ErrInvalidTicket) {
+		t.Errorf("校验别的service的票据返回%v，应为ErrInvalidTicket", err)
+	}
+}
+
+// TestClientConcurrentUse检查同一个客户端被多个协程同时使用时没有数据竞争，需要用-race运行。
+func TestClientConcurrentUse(t *testing.T) {
+	site, srv, e := startSite(t)
+	c := newClient(t, site, e)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.Valid(service(srv))
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := c.NewSession(); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, jsessionid := c.Cookies(); jsessionid != nil {
+				c.Login("test", "secret", site.Captcha(jsessionid.Value))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientValidateTicketViaProxy(t *testing.T) {
+	site, srv, e := startSite(t)
+	site.RequireProxy = true
+	proxy := fakesite.NewProxy()
+	proxySrv := proxy.Start()
+	defer proxySrv.Close()
+	proxyUrl, err := url.Parse(proxySrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cas.NewClient(e, fakeHeader)
+	c.SetProxy(proxyUrl)
+	login(t, site, c)
+	ticket, err := c.ServiceTicket(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 包函数不经过代理，被只接受代理请求的站点拒绝，票据也没有被用掉。
+	if _, err := cas.ValidateTicket(e, service(srv), ticket); err == nil {
+		t.Error("不经过代理校验票据时应当失败")
+	}
+	before := proxy.Requests()
+	p, err := c.ValidateTicket(service(srv), ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.User != "test" {
+		t.Errorf("票据所属的用户为%q，应为test", p.User)
+	}
+	if proxy.Requests() == before {
+		t.Error("Client.ValidateTicket没有经过代理")
+	}
+}
+
+// TestClientViaProxies检查客户端经由HTTP代理和SOCKS5代理都能登录只接受代理请求的站点。
+func TestClientViaProxies(t *testing.T) {
+	site, srv, e := startSite(t)
+	site.RequireProxy = true
+	socks := fakesite.NewSocks5()
+	socksAddr, err := socks.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	site.Socks = socks
+	proxySrv := fakesite.NewProxy().Start()
+	defer proxySrv.Close()
+
+	if _, _, err := cas.NewSessionAndGetRawCaptcha(e, fakeHeader); err == nil {
+		t.Error("不经过代理访问只接受代理请求的站点时应当失败")
+	}
+	for _, addr := range []string{proxySrv.URL, socksAddr} {
+		proxyUrl, err := url.Parse(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cas.NewClient(e, fakeHeader)
+		c.SetProxy(proxyUrl)
+		login(t, site, c)
+		if !c.Valid(service(srv)) {
+			t.Errorf("经由%s登录后TGC无效", addr)
+		}
+	}
+	if socks.Connections() == 0 {
+		t.Error("没有请求经过SOCKS5代理")
+	}
+}
+
+// TestClientGzip检查站点压缩响应时，带有Accept-Encoding的伪造头部不会让调用者拿到压缩过的响应体。
+func TestClientGzip(t *testing.T) {
+	site, srv, e := startSite(t)
+	site.Gzip = true
+	header := map[string]string{"Accept-Encoding": "gzip, deflate, br"}
+	for k, v := range fakeHeader {
+		header[k] = v
+	}
+
+	c := cas.NewClient(e, header)
+	login(t, site, c)
+	ticket, err := c.ServiceTicket(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.ValidateTicket(service(srv), ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.User != "test" {
+		t.Errorf("票据所属的用户为%q，应为test", p.User)
+	}
+
+	hc, err := c.ServiceClient(service(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hc.Get(srv.URL + "/infoplus/form/XNYQSB/start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Uncompressed || !strings.Contains(string(body), "<html") {
+		if len(body) > 16 {
+			body = body[:16]
+		}
+		t.Errorf("申报表页面没有被解压：%q", body)
+	}
+}
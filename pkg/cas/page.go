@@ -0,0 +1,196 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// 登录失败的几种类型，用errors.Is判断LoginCas返回的错误属于哪一种。
+var (
+	// ErrCaptcha表示验证码错误。
+	ErrCaptcha = errors.New("验证码错误")
+	// ErrCredentials表示用户名或密码错误。
+	ErrCredentials = errors.New("用户名或密码错误")
+	// ErrLocked表示账户被锁定或者尝试次数过多。
+	ErrLocked = errors.New("账户被锁定")
+	// ErrLoginFailed表示其他原因的登录失败，比如页面过期、请求被拒绝。
+	ErrLoginFailed = errors.New("登录失败")
+)
+
+// LoginError是登录cas系统失败的错误，Kind是上面几种失败类型之一，Message是页面上显示的错误信息。
+type LoginError struct {
+	Kind    error
+	Message string
+}
+
+func (e *LoginError) Error() string {
+	if e.Message == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s：%s", e.Kind.Error(), e.Message)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Kind
+}
+
+// newLoginError根据页面上的错误信息判断失败的类型。
+func newLoginError(message string) *LoginError {
+	kind := ErrLoginFailed
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "验证码") || strings.Contains(lower, "captcha"):
+		kind = ErrCaptcha
+	case strings.Contains(message, "锁定") || strings.Contains(message, "次数过多") || strings.Contains(lower, "locked"):
+		kind = ErrLocked
+	case strings.Contains(message, "密码") || strings.Contains(message, "认证信息无效") || strings.Contains(lower, "credentials"):
+		kind = ErrCredentials
+	}
+	return &LoginError{Kind: kind, Message: message}
+}
+
+// LoginPage是解析后的cas登录页面。
+type LoginPage struct {
+	// Action是登录表单提交的绝对地址。
+	Action string
+	// Hidden是登录表单中所有的隐藏字段，比如execution、lt、_eventId。
+	Hidden url.Values
+	// CaptchaRequired表示登录表单中有验证码输入框。
+	CaptchaRequired bool
+	// Error是页面上显示的错误信息，没有则为空串。
+	Error string
+}
+
+// captchaFields是验证码输入框可能的name或id。
+var captchaFields = []string{"captcha", "validatecode", "authcode", "captcharesponse"}
+
+// voidElements是没有结束标签的元素，统计错误信息元素的嵌套深度时不能计入。
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// form是页面上的一个表单。
+type form struct {
+	action      string
+	hidden      url.Values
+	hasPassword bool
+	hasCaptcha  bool
+}
+
+// ParseLoginPage用HTML解析器解析cas登录页面，找出含有密码输入框的登录表单，pageUrl是页面
+// 本身的地址，用来把表单的action解析为绝对地址。页面上没有登录表单时返回错误，但若页面上有
+// 错误信息，仍然会一起返回。
+func ParseLoginPage(r io.Reader, pageUrl *url.URL) (*LoginPage, error) {
+	z := html.NewTokenizer(r)
+	forms := []*form{}
+	var current *form
+	page := &LoginPage{}
+
+	// errorDepth大于0时表示正处于错误信息元素之中，记录它的文本。
+	errorDepth := 0
+	errorText := strings.Builder{}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				break
+			}
+			return nil, z.Err()
+		}
+
+		tok := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs := map[string]string{}
+			for _, a := range tok.Attr {
+				attrs[strings.ToLower(a.Key)] = a.Val
+			}
+
+			if errorDepth > 0 {
+				if tt == html.StartTagToken && !voidElements[tok.Data] {
+					errorDepth++
+				}
+			} else if page.Error == "" && tt == html.StartTagToken && !voidElements[tok.Data] && isErrorElement(attrs) {
+				errorDepth = 1
+			}
+
+			switch tok.Data {
+			case "form":
+				current = &form{action: attrs["action"], hidden: url.Values{}}
+				forms = append(forms, current)
+			case "input":
+				if current == nil {
+					continue
+				}
+				name := attrs["name"]
+				switch strings.ToLower(attrs["type"]) {
+				case "hidden":
+					if name != "" {
+						current.hidden.Add(name, attrs["value"])
+					}
+				case "password":
+					current.hasPassword = true
+				}
+				for _, f := range captchaFields {
+					if strings.ToLower(name) == f || strings.ToLower(attrs["id"]) == f {
+						current.hasCaptcha = true
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			if errorDepth > 0 {
+				errorDepth--
+				if errorDepth == 0 {
+					page.Error = strings.Join(strings.Fields(errorText.String()), " ")
+				}
+			}
+			if tok.Data == "form" {
+				current = nil
+			}
+
+		case html.TextToken:
+			if errorDepth > 0 {
+				errorText.WriteString(tok.Data)
+				errorText.WriteByte(' ')
+			}
+		}
+	}
+
+	for _, f := range forms {
+		if !f.hasPassword {
+			continue
+		}
+		action, err := pageUrl.Parse(f.action)
+		if err != nil {
+			return page, fmt.Errorf("登录表单的action不正确：%s", err.Error())
+		}
+		page.Action = action.String()
+		page.Hidden = f.hidden
+		page.CaptchaRequired = f.hasCaptcha
+		return page, nil
+	}
+	return page, fmt.Errorf("登录页面中没有登录表单")
+}
+
+// isErrorElement判断一个元素是否是显示错误信息的元素，Apereo CAS的模板里通常是id为msg或
+// errorDiv、class带有errors或alert-danger的元素。
+func isErrorElement(attrs map[string]string) bool {
+	id := strings.ToLower(attrs["id"])
+	if id == "msg" || id == "errordiv" || id == "errormsg" {
+		return true
+	}
+	for _, class := range strings.Fields(attrs["class"]) {
+		if class == "errors" || class == "alert-danger" || class == "error" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package cas
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTicket表示服务票据校验失败，比如票据不存在、已经用过或者与service不符。
+var ErrInvalidTicket = errors.New("服务票据无效")
+
+// TicketError是校验服务票据失败的错误，Code和Message是cas系统返回的authenticationFailure。
+type TicketError struct {
+	Code    string
+	Message string
+}
+
+func (e *TicketError) Error() string {
+	return fmt.Sprintf("%s：%s %s", ErrInvalidTicket.Error(), e.Code, e.Message)
+}
+
+func (e *TicketError) Unwrap() error {
+	return ErrInvalidTicket
+}
+
+// Principal是服务票据校验成功后cas系统返回的用户信息。
+type Principal struct {
+	User       string
+	Attributes map[string][]string
+}
+
+// serviceResponse是CAS 2.0/3.0协议中/serviceValidate返回的XML。
+type serviceResponse struct {
+	Success *struct {
+		User       string `xml:"user"`
+		Attributes struct {
+			Values []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"attributes"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code    string `xml:"code,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"authenticationFailure"`
+}
+
+// ValidateTicket通过e所指的cas系统的/serviceValidate校验服务票据ticket是否是为service签发的，
+// 成功时返回票据所属的用户，失败时返回*TicketError。票据无论校验成功与否都只能用一次。请求直接
+// 发出，不带伪造头部，也不经过代理，需要时请用Client.ValidateTicket。
+func ValidateTicket(e Endpoints, service, ticket string) (*Principal, error) {
+	return validateTicket(&http.Client{Timeout: DefaultTimeout}, e, service, ticket)
+}
+
+// ValidateTicket与包函数ValidateTicket相同，但请求与c的其他请求一样经由c的代理、带上伪造头部。
+func (c *Client) ValidateTicket(service, ticket string) (*Principal, error) {
+	hc := &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: &headerTransport{header: c.fakeHeader, base: c.transport()},
+	}
+	return validateTicket(hc, c.endpoints, service, ticket)
+}
+
+// validateTicket用hc校验服务票据，见ValidateTicket。
+func validateTicket(hc *http.Client, e Endpoints, service, ticket string) (*Principal, error) {
+	validateUrl, err := e.serviceValidateUrl()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(validateUrl)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("service", service)
+	q.Set("ticket", ticket)
+	u.RawQuery = q.Encode()
+
+	resp, err := hc.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("serviceValidate返回%s", resp.Status)
+	}
+
+	sr := serviceResponse{}
+	if err := xml.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, fmt.Errorf("无法解析serviceValidate的响应：%s", err.Error())
+	}
+	if sr.Failure != nil {
+		return nil, &TicketError{Code: sr.Failure.Code, Message: strings.TrimSpace(sr.Failure.Message)}
+	}
+	if sr.Success == nil || sr.Success.User == "" {
+		return nil, &TicketError{Code: "INVALID_RESPONSE", Message: "响应中没有用户"}
+	}
+
+	p := &Principal{User: strings.TrimSpace(sr.Success.User), Attributes: map[string][]string{}}
+	for _, v := range sr.Success.Attributes.Values {
+		p.Attributes[v.XMLName.Local] = append(p.Attributes[v.XMLName.Local], strings.TrimSpace(v.Value))
+	}
+	return p, nil
+}
+
+// NewClientWithTgc用已有的TGC在e所指的cas系统上新建一个客户端，比如TGC来自浏览器或者别的
+// 程序。TGC没有Expires时假定它还有DefaultTgcLifetime的有效期。
+func NewClientWithTgc(e Endpoints, tgc *http.Cookie, fakeHeader map[string]string) (*Client, error) {
+	loginUrl, err := url.Parse(e.LoginUrl)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(e, fakeHeader)
+	c.httpClient.Jar.SetCookies(loginUrl, []*http.Cookie{tgc})
+	c.tgc = tgc
+	c.expiry = tgc.Expires
+	if c.expiry.IsZero() {
+		c.expiry = time.Now().Add(DefaultTgcLifetime)
+	}
+	return c, nil
+}
+
+// ServiceClient用缓存的TGC申请访问service的服务票据，带着票据访问service并跟随重定向，返回
+// 已经登录了service的http.Client。返回的客户端有自己的cookie jar，与c使用同样的代理，每个请求都会带上fakeHeader。
+// TGC失效时返回ErrTicketRejected。
+func (c *Client) ServiceClient(service string) (*http.Client, error) {
+	ticket, err := c.ServiceTicket(service)
+	if err != nil {
+		return nil, err
+	}
+	// 原样保留service，只在末尾加上ticket参数，service的其余部分要与申请票据时一致。
+	sep := "?"
+	if strings.Contains(service, "?") {
+		sep = "&"
+	}
+	jar, _ := cookiejar.New(nil)
+	hc := &http.Client{
+		Jar:       jar,
+		Timeout:   DefaultTimeout,
+		Transport: &headerTransport{header: c.fakeHeader, base: c.transport()},
+	}
+	resp, err := hc.Get(service + sep + "ticket=" + url.QueryEscape(ticket))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("用服务票据登录%s失败：%s", service, resp.Status)
+	}
+	return hc, nil
+}
+
+// headerTransport给每个请求加上伪造头部，请求中已有的头部不会被覆盖，Accept-Encoding除外，见skipHeader。
+type headerTransport struct {
+	header map[string]string
+	base   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		if !skipHeader(k) && req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	return t.base.RoundTrip(req)
+}